@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// FieldACL holds the fields a role can read and write
+type FieldACL struct {
+	Read  []string `json:"read"`
+	Write []string `json:"write"`
+}
+
+// datacenterFieldACL : returns the datacenter field ACL of the given role
+// as configured on DATACENTER_FIELD_ACL, a json object mapping roles to
+// their ACL, as {"viewer":{"read":["id","name"]},"member":{"read":["id",
+// "name"],"write":["name"]}}. Group roles without an ACL get the member one,
+// and roles without any can read and write all fields
+func datacenterFieldACL(role string) (acl FieldACL, ok bool) {
+	v := os.Getenv("DATACENTER_FIELD_ACL")
+	if v == "" {
+		return acl, false
+	}
+
+	acls := make(map[string]FieldACL)
+	if err := json.Unmarshal([]byte(v), &acls); err != nil {
+		log.Println("Invalid DATACENTER_FIELD_ACL: " + err.Error())
+		return acl, false
+	}
+
+	if acl, ok = acls[role]; !ok && roleRanks[role] > 0 {
+		acl, ok = acls["member"]
+	}
+	return acl, ok
+}
+
+// CanRead : checks if the field is readable
+func (a FieldACL) CanRead(field string) bool {
+	return hasField(a.Read, field)
+}
+
+// CanWrite : checks if the field is writable
+func (a FieldACL) CanWrite(field string) bool {
+	return hasField(a.Write, field)
+}
+
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// clearUnreadable : sets to its zero value every field of the given struct
+// pointer whose json name is not readable on the ACL
+func clearUnreadable(o interface{}, acl FieldACL) {
+	v := reflect.ValueOf(o).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || acl.CanRead(name) {
+			continue
+		}
+		v.Field(i).Set(reflect.Zero(t.Field(i).Type))
+	}
+}
@@ -0,0 +1,110 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+// APIKey holds an automation key configured on API_KEYS and the identity
+// and request rate granted to it
+type APIKey struct {
+	Key            string  `json:"key"`
+	Username       string  `json:"username"`
+	GroupID        int     `json:"group_id"`
+	Admin          bool    `json:"admin"`
+	RateLimit      float64 `json:"rate_limit"`
+	RateLimitBurst float64 `json:"rate_limit_burst"`
+}
+
+// apiKeys : returns the keys configured on API_KEYS, a json object mapping
+// each key name to its config, as {"ci":{"key":"...","username":"ci",
+// "group_id":1,"rate_limit":1}}
+func apiKeys() map[string]APIKey {
+	keys := make(map[string]APIKey)
+	if v := os.Getenv("API_KEYS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &keys); err != nil {
+			log.Println("Invalid API_KEYS: " + err.Error())
+		}
+	}
+	return keys
+}
+
+// findAPIKey : returns the name and config of the api key matching the
+// given bearer if any
+func findAPIKey(bearer string) (string, APIKey, bool) {
+	for name, k := range apiKeys() {
+		if k.Key != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(k.Key)) == 1 {
+			return name, k, true
+		}
+	}
+	return "", APIKey{}, false
+}
+
+// Token : returns the token of the user the key authenticates as
+func (k APIKey) Token() *jwt.Token {
+	claims := make(jwt.MapClaims)
+	claims["group_id"] = float64(k.GroupID)
+	claims["username"] = k.Username
+	claims["admin"] = k.Admin
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+}
+
+var apiKeyLimiters = &keyLimiters{limiters: make(map[string]*rateLimiter)}
+
+// keyLimiters keeps in memory the rate limiter of each api key
+type keyLimiters struct {
+	sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+// Get : returns the limiter of the named key, created with its configured
+// rate on first use
+func (l *keyLimiters) Get(name string, k APIKey) *rateLimiter {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.limiters[name]; !ok {
+		burst := k.RateLimitBurst
+		if burst < 1 {
+			burst = 1
+		}
+		l.limiters[name] = newRateLimiter(k.RateLimit, burst)
+	}
+	return l.limiters[name]
+}
+
+// apiKeyRateLimit : middleware limiting the requests authenticated with an
+// api key to the rate configured for it, independently of the per user
+// rate limit
+func apiKeyRateLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name, ok := c.Get("api_key").(string)
+		if !ok {
+			return next(c)
+		}
+
+		k, ok := apiKeys()[name]
+		if !ok || k.RateLimit <= 0 {
+			return next(c)
+		}
+
+		if ok, wait := apiKeyLimiters.Get(name, k).Take(name); !ok {
+			c.Response().Header().Set("Retry-After", retryAfter(wait))
+			return echo.NewHTTPError(http.StatusTooManyRequests, "Rate limit exceeded for api key "+name)
+		}
+
+		return next(c)
+	}
+}
@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	headers := map[string]string{"Authorization": "Bearer ci-secret"}
+
+	Convey("Scenario: rate limiting requests authenticated with an api key", t, func() {
+		users := newRateLimiter(1, 2)
+		h := handle(jwtAuth([]byte("test"))(apiKeyRateLimit(rateLimit(users)(okHandler))))
+		apiKeyLimiters = &keyLimiters{limiters: make(map[string]*rateLimiter)}
+		_ = os.Setenv("API_KEYS", `{"ci":{"key":"ci-secret","username":"ci","group_id":1,"rate_limit":0.01}}`)
+
+		Convey("Given the key limit is lower than the user one", func() {
+			first, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+			So(err, ShouldBeNil)
+			So(first.Body.String(), ShouldEqual, "ok")
+
+			Convey("When the key is used again", func() {
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+
+				Convey("Then it should be throttled by the key limit", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 429)
+					So(rec.Header().Get("Retry-After"), ShouldNotEqual, "")
+				})
+
+				Convey("Then the user limit should not be hit", func() {
+					ok, _ := users.Take("ci@1")
+					So(ok, ShouldBeTrue)
+				})
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("API_KEYS")
+		})
+	})
+}
@@ -0,0 +1,91 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// AuditEvent holds a change event from audit-store
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	Entity    string    `json:"entity"`
+	EntityID  int       `json:"entity_id"`
+	Name      string    `json:"name,omitempty"`
+	User      string    `json:"user"`
+	GroupID   int       `json:"group_id,omitempty"`
+	Action    string    `json:"action"`
+	Changes   []string  `json:"changes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditPage holds a page of audit events and the cursor to the next one
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+// FindByEntity : Searches for all audit events of the given entity
+func (a *AuditEvent) FindByEntity(ctx context.Context, entity string, id int, events *[]AuditEvent) (err error) {
+	query := make(map[string]interface{})
+	query["entity"] = entity
+	query["entity_id"] = id
+	if err := NewBaseModel("audit").FindBy(ctx, query, events); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PaginateAuditEvents : returns up to limit events ordered by id with an id
+// greater than after, and the cursor to the next page if any
+func PaginateAuditEvents(events []AuditEvent, after int, limit int) (page AuditPage) {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	page.Events = []AuditEvent{}
+	for _, e := range events {
+		if e.ID <= after {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.NextCursor = encodeCursor(page.Events[limit-1].ID)
+			break
+		}
+		page.Events = append(page.Events, e)
+	}
+
+	return page
+}
+
+// auditDatacenter : publishes on audit.datacenter the action the
+// authenticated user did on the datacenter. It is best effort, failures are
+// logged without failing the request
+func auditDatacenter(c echo.Context, action string, d Datacenter, changes []string) {
+	au := authenticatedUser(c)
+	e := AuditEvent{
+		Entity:    "datacenter",
+		EntityID:  d.ID,
+		Name:      d.Name,
+		User:      au.Username,
+		GroupID:   au.GroupID,
+		Action:    action,
+		Changes:   changes,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = publish("audit.datacenter", data)
+	}
+	if err != nil {
+		logError(c, err)
+	}
+}
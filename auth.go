@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -15,6 +16,7 @@ import (
 )
 
 func authenticate(c echo.Context) error {
+	ctx := c.Request().Context()
 	var u User
 
 	username := c.FormValue("username")
@@ -22,7 +24,10 @@ func authenticate(c echo.Context) error {
 
 	// Find user, sending the auth request as payload
 	req := fmt.Sprintf(`{"username": "%s"}`, username)
-	msg, err := n.Request("user.get", []byte(req), 5*time.Second)
+	msg, err := request(ctx, "user.get", []byte(req), 5*time.Second)
+	if err == errNatsDisconnected {
+		return ErrServiceUnavailable
+	}
 	if err != nil {
 		return ErrGatewayTimeout
 	}
@@ -46,7 +51,16 @@ func authenticate(c echo.Context) error {
 		claims["group_id"] = u.GroupID
 		claims["username"] = u.Username
 		claims["admin"] = u.Admin
+		if u.Roles != nil {
+			claims["roles"] = u.Roles
+		}
 		claims["exp"] = time.Now().Add(time.Hour * 48).Unix()
+		if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+			claims["iss"] = iss
+		}
+		if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+			claims["aud"] = aud
+		}
 
 		// Create token
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -5,8 +5,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -21,7 +24,7 @@ func NewBaseModel(t string) *BaseModel {
 	return &BaseModel{Type: t}
 }
 
-func (b *BaseModel) callStoreBy(verb string, query map[string]interface{}, o interface{}) (err error) {
+func (b *BaseModel) callStoreBy(ctx context.Context, verb string, query map[string]interface{}, o interface{}) (err error) {
 	var res []byte
 	var req []byte
 	if len(query) > 0 {
@@ -29,7 +32,7 @@ func (b *BaseModel) callStoreBy(verb string, query map[string]interface{}, o int
 			return err
 		}
 	}
-	if res, err = b.Query(b.Type+"."+verb, string(req)); err != nil {
+	if res, err = b.Query(ctx, b.Type+"."+verb, string(req)); err != nil {
 		return err
 	}
 	if err = json.Unmarshal(res, &o); err != nil {
@@ -40,17 +43,17 @@ func (b *BaseModel) callStoreBy(verb string, query map[string]interface{}, o int
 }
 
 // GetBy : interface to call component.get on the specific store
-func (b *BaseModel) GetBy(query map[string]interface{}, o interface{}) (err error) {
-	return b.callStoreBy("get", query, o)
+func (b *BaseModel) GetBy(ctx context.Context, query map[string]interface{}, o interface{}) (err error) {
+	return b.callStoreBy(ctx, "get", query, o)
 }
 
 // FindBy : interface to call component.find on the specific store
-func (b *BaseModel) FindBy(query map[string]interface{}, o interface{}) (err error) {
-	return b.callStoreBy("find", query, o)
+func (b *BaseModel) FindBy(ctx context.Context, query map[string]interface{}, o interface{}) (err error) {
+	return b.callStoreBy(ctx, "find", query, o)
 }
 
 // Save : interface to call component.set on the specific store
-func (b *BaseModel) Save(o interface{}) (err error) {
+func (b *BaseModel) Save(ctx context.Context, o interface{}) (err error) {
 	var res []byte
 
 	data, err := json.Marshal(o)
@@ -58,7 +61,7 @@ func (b *BaseModel) Save(o interface{}) (err error) {
 		return ErrBadReqBody
 	}
 
-	if res, err = b.Query(b.Type+".set", string(data)); err != nil {
+	if res, err = b.Query(ctx, b.Type+".set", string(data)); err != nil {
 		return err
 	}
 	if err := json.Unmarshal(res, &o); err != nil {
@@ -71,7 +74,7 @@ func (b *BaseModel) Save(o interface{}) (err error) {
 }
 
 // Delete : interface to call component.del on the specific store
-func (b *BaseModel) Delete(query map[string]interface{}) (err error) {
+func (b *BaseModel) Delete(ctx context.Context, query map[string]interface{}) (err error) {
 	var res []byte
 	var req []byte
 	if len(query) > 0 {
@@ -79,7 +82,7 @@ func (b *BaseModel) Delete(query map[string]interface{}) (err error) {
 			return err
 		}
 	}
-	if res, err = b.Query(b.Type+".del", string(req)); err != nil {
+	if res, err = b.Query(ctx, b.Type+".del", string(req)); err != nil {
 		return err
 	}
 	if strings.Contains(string(res), `"error"`) {
@@ -89,10 +92,41 @@ func (b *BaseModel) Delete(query map[string]interface{}) (err error) {
 	return nil
 }
 
+// DefaultRequestTimeout : time to wait for the stores to reply when
+// NATS_REQUEST_TIMEOUT is not set
+const DefaultRequestTimeout = 5 * time.Second
+
+// requestTimeout : returns the time to wait for the stores to reply, as
+// configured on NATS_REQUEST_TIMEOUT with a duration like "2s"
+func requestTimeout() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("NATS_REQUEST_TIMEOUT")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultRequestTimeout
+}
+
+// maxPayload : max size in bytes of the messages published to NATS, as
+// configured on NATS_MAX_PAYLOAD or advertised by the server
+func maxPayload() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("NATS_MAX_PAYLOAD"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	if n == nil {
+		return 0
+	}
+	return n.MaxPayload()
+}
+
 // Query : Allows a free query by subject
-func (b *BaseModel) Query(subject, query string) ([]byte, error) {
+func (b *BaseModel) Query(ctx context.Context, subject, query string) ([]byte, error) {
 	var res []byte
-	msg, err := n.Request(subject, []byte(query), 5*time.Second)
+	if max := maxPayload(); max > 0 && int64(len(query)) > max {
+		return res, ErrPayloadTooLarge
+	}
+	msg, err := request(ctx, subject, []byte(query), requestTimeout())
+	if err == errNatsDisconnected {
+		return res, ErrServiceUnavailable
+	}
 	if err != nil {
 		return res, ErrGatewayTimeout
 	}
@@ -105,7 +139,7 @@ func (b *BaseModel) Query(subject, query string) ([]byte, error) {
 }
 
 // Set : interface to call component.set on the specific store
-func (b *BaseModel) Set(query map[string]interface{}) (err error) {
+func (b *BaseModel) Set(ctx context.Context, query map[string]interface{}) (err error) {
 	var req []byte
 	if len(query) > 0 {
 		if req, err = json.Marshal(query); err != nil {
@@ -113,7 +147,7 @@ func (b *BaseModel) Set(query map[string]interface{}) (err error) {
 		}
 	}
 
-	if _, err = b.Query(b.Type+".set", string(req)); err != nil {
+	if _, err = b.Query(ctx, b.Type+".set", string(req)); err != nil {
 		return err
 	}
 
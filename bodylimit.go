@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultMaxBodyBytes : maximum size of a request body when MAX_BODY_BYTES
+// is not set
+const DefaultMaxBodyBytes = 1 << 20
+
+// mutatingMethods : methods whose request bodies are limited
+var mutatingMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// bodyLimit : middleware rejecting with a 413 the mutating requests whose
+// body is larger than MAX_BODY_BYTES, without reading more than that
+func bodyLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if !mutatingMethods[req.Method] || req.Body == nil {
+			return next(c)
+		}
+
+		max := int64(envPositiveInt("MAX_BODY_BYTES", DefaultMaxBodyBytes))
+		if req.ContentLength > max {
+			return ErrBodyTooLarge
+		}
+
+		// the length may be unknown, so read one byte past the limit
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+		if err != nil {
+			return ErrBadReqBody
+		}
+		if int64(len(body)) > max {
+			return ErrBodyTooLarge
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		return next(c)
+	}
+}
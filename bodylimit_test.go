@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBodyLimit(t *testing.T) {
+	h := handle(bodyLimit(echoBodyHandler))
+
+	Convey("Scenario: limiting the size of request bodies", t, func() {
+		_ = os.Setenv("MAX_BODY_BYTES", "32")
+
+		Convey("Given a body larger than the limit", func() {
+			data := bytes.Repeat([]byte("a"), 64)
+
+			Convey("When I post it", func() {
+				_, err := doRequest("POST", "/api/datacenters/", nil, data, h, nil)
+
+				Convey("Then I should get a 413 error", func() {
+					So(err, ShouldEqual, ErrBodyTooLarge)
+				})
+			})
+		})
+
+		Convey("Given a body within the limit", func() {
+			data := []byte(`{"name":"test"}`)
+
+			Convey("When I post it", func() {
+				resp, err := doRequest("POST", "/api/datacenters/", nil, data, h, nil)
+
+				Convey("Then it should reach the handler intact", func() {
+					So(err, ShouldBeNil)
+					So(string(resp), ShouldEqual, string(data))
+				})
+			})
+		})
+
+		Convey("Given a non mutating request", func() {
+			data := bytes.Repeat([]byte("a"), 64)
+
+			Convey("When I send it", func() {
+				_, err := doRequest("GET", "/api/datacenters/", nil, data, h, nil)
+
+				Convey("Then it should not be limited", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("MAX_BODY_BYTES")
+		})
+	})
+}
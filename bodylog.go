@@ -0,0 +1,126 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultBodyLogMaxSize : maximum number of bytes logged for each body when
+// BODY_LOG_MAX_SIZE is not set
+const DefaultBodyLogMaxSize = 4096
+
+// secretFieldNames : names of the fields redacted from logged bodies
+var secretFieldNames = []string{"password", "oldpassword", "secret", "token", "aws_access_key_id", "aws_secret_access_key", "azure_client_secret", "gcp_service_account_json"}
+
+// secretFields : matches the json string fields redacted from logged
+// bodies, including values with escaped quotes as embedded json documents
+var secretFields = regexp.MustCompile(`"(` + strings.Join(secretFieldNames, "|") + `)"\s*:\s*"(?:[^"\\]|\\.)*"`)
+
+// bodyLogWriter copies the response body while it is written
+type bodyLogWriter struct {
+	io.Writer
+	http.ResponseWriter
+}
+
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	return w.Writer.Write(b)
+}
+
+// redactBody : hides secret fields and truncates the body to max bytes
+func redactBody(body []byte, max int) string {
+	b := secretFields.ReplaceAll(body, []byte(`"$1":"***"`))
+	if len(b) > max {
+		return string(b[:max]) + "...(truncated)"
+	}
+	return string(b)
+}
+
+// redactForm : hides the secret fields of a form encoded body, keys which
+// can't be decoded are hidden too
+func redactForm(body []byte) []byte {
+	pairs := strings.Split(string(body), "&")
+	for i, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		key, err := url.QueryUnescape(kv[0])
+		if err != nil || isSecretField(key) {
+			pairs[i] = kv[0] + "=***"
+		}
+	}
+	return []byte(strings.Join(pairs, "&"))
+}
+
+// isSecretField : checks if the field is redacted from logged bodies
+func isSecretField(name string) bool {
+	for _, f := range secretFieldNames {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyLogPaths : returns the path prefixes configured on BODY_LOG_PATHS
+func bodyLogPaths() (paths []string) {
+	for _, p := range strings.Split(os.Getenv("BODY_LOG_PATHS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// bodyLogger : middleware logging the redacted request and response bodies
+// of the routes matching any of the BODY_LOG_PATHS prefixes
+func bodyLogger(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+		matched := false
+		for _, p := range bodyLogPaths() {
+			if strings.HasPrefix(path, p) {
+				matched = true
+			}
+		}
+		if !matched {
+			return next(c)
+		}
+
+		max, err := strconv.Atoi(os.Getenv("BODY_LOG_MAX_SIZE"))
+		if err != nil || max <= 0 {
+			max = DefaultBodyLogMaxSize
+		}
+
+		var reqBody []byte
+		if c.Request().Body != nil {
+			reqBody, _ = ioutil.ReadAll(c.Request().Body)
+			c.Request().Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		}
+		logged := reqBody
+		if strings.HasPrefix(c.Request().Header.Get("Content-Type"), echo.MIMEApplicationForm) {
+			logged = redactForm(reqBody)
+		}
+
+		resBody := new(bytes.Buffer)
+		w := c.Response().Writer
+		c.Response().Writer = &bodyLogWriter{Writer: io.MultiWriter(w, resBody), ResponseWriter: w}
+
+		err = next(c)
+
+		log.Printf("DEBUG %s %s request=%s response=%s", c.Request().Method, path, redactBody(logged, max), redactBody(resBody.Bytes(), max))
+
+		return err
+	}
+}
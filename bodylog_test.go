@@ -0,0 +1,83 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func echoBodyHandler(c echo.Context) error {
+	body, _ := ioutil.ReadAll(c.Request().Body)
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+func TestBodyLogger(t *testing.T) {
+	h := handle(bodyLogger(echoBodyHandler))
+	data := []byte(`{"name":"test","password":"secret"}`)
+
+	Convey("Scenario: logging request and response bodies", t, func() {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		_ = os.Setenv("BODY_LOG_PATHS", "/api/datacenters")
+
+		Convey("Given a request to a targeted route", func() {
+			resp, err := doRequest("POST", "/api/datacenters/", nil, data, h, nil)
+
+			Convey("Then the bodies should be logged with secrets redacted", func() {
+				So(err, ShouldBeNil)
+				So(string(resp), ShouldEqual, string(data))
+				So(buf.String(), ShouldContainSubstring, `request={"name":"test","password":"***"}`)
+				So(buf.String(), ShouldContainSubstring, `response={"name":"test","password":"***"}`)
+				So(buf.String(), ShouldNotContainSubstring, "secret")
+			})
+		})
+
+		Convey("Given a form encoded request to a targeted route", func() {
+			_ = os.Setenv("BODY_LOG_PATHS", "/auth")
+			form := []byte("username=test&password=s%26cret&token=abc")
+			auth := handle(bodyLogger(func(c echo.Context) error {
+				return c.JSON(http.StatusOK, map[string]string{"token": "jwt"})
+			}))
+			_, err := doRequestRecorder("POST", "/auth", nil, form, auth, nil, map[string]string{"Content-Type": echo.MIMEApplicationForm})
+
+			Convey("Then the secret form values should be redacted", func() {
+				So(err, ShouldBeNil)
+				So(buf.String(), ShouldContainSubstring, "request=username=test&password=***&token=***")
+				So(buf.String(), ShouldNotContainSubstring, "cret")
+				So(buf.String(), ShouldNotContainSubstring, "abc")
+			})
+		})
+
+		Convey("Given a request to a non targeted route", func() {
+			_, err := doRequest("POST", "/api/users/", nil, data, h, nil)
+
+			Convey("Then nothing should be logged", func() {
+				So(err, ShouldBeNil)
+				So(buf.String(), ShouldNotContainSubstring, "DEBUG")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stderr)
+			_ = os.Unsetenv("BODY_LOG_PATHS")
+		})
+	})
+
+	Convey("Scenario: redacting an embedded json document", t, func() {
+		body := []byte(`{"name":"g","gcp_service_account_json":"{\"private_key\":\"key\"}"}`)
+
+		Convey("Then the whole document should be redacted", func() {
+			So(redactBody(body, DefaultBodyLogMaxSize), ShouldEqual, `{"name":"g","gcp_service_account_json":"***"}`)
+		})
+	})
+}
@@ -0,0 +1,76 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// cacheMaxAges : default max-age in seconds for each cacheable endpoint
+// class, it can be overridden with CACHE_MAX_AGE_<CLASS>
+var cacheMaxAges = map[string]int{
+	"types": 3600,
+}
+
+// cacheMaxAge : returns the configured max-age for the given class
+func cacheMaxAge(class string) int {
+	if v := os.Getenv("CACHE_MAX_AGE_" + strings.ToUpper(class)); v != "" {
+		if age, err := strconv.Atoi(v); err == nil {
+			return age
+		}
+	}
+
+	return cacheMaxAges[class]
+}
+
+// noStore : middleware preventing clients and proxies from caching any
+// response
+func noStore(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return next(c)
+	}
+}
+
+// cacheControl : middleware allowing responses of the given endpoint class
+// to be cached by the client for its configured max-age. Responses depend
+// on the authenticated user, so shared caches must not store them
+func cacheControl(class string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			age := cacheMaxAge(class)
+			if age <= 0 {
+				c.Response().Header().Set("Cache-Control", "no-store")
+			} else {
+				c.Response().Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(age))
+			}
+			return next(c)
+		}
+	}
+}
+
+// etag : returns a strong entity tag for the given response body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified : checks if the request If-None-Match header matches the
+// given entity tag, weak comparison is used as it is for conditional GETs
+func notModified(c echo.Context, tag string) bool {
+	for _, t := range strings.Split(c.Request().Header.Get("If-None-Match"), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -15,13 +15,14 @@ import (
 
 // getAllComponentsHandler : ...
 func getAllComponentsHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var body []byte
 	var d Datacenter
 
 	parts := strings.Split(c.Path(), "/")
 	component := parts[len(parts)-2] + "s"
 
-	if err := d.FindByName(c.QueryParam("datacenter"), &d); err != nil {
+	if err := d.FindByName(ctx, c.QueryParam("datacenter"), &d); err != nil {
 		return err
 	}
 
@@ -38,8 +39,8 @@ func getAllComponentsHandler(c echo.Context) (err error) {
 	}
 
 	components := make(map[string]interface{})
-	if err = NewBaseModel(component).callStoreBy("find.aws", query, &components); err != nil {
-		return c.JSONBlob(500, []byte("An internal error occured"))
+	if err = NewBaseModel(component).callStoreBy(ctx, "find.aws", query, &components); err != nil {
+		return echo.NewHTTPError(500, "An internal error occured")
 	}
 
 	if components["components"] == nil {
@@ -58,7 +59,7 @@ func getAllComponentsHandler(c echo.Context) (err error) {
 	}
 
 	if body, err = json.Marshal(list); err != nil {
-		return c.JSONBlob(500, []byte("Oops, somethign went wrong"))
+		return echo.NewHTTPError(500, "Oops, somethign went wrong")
 	}
 
 	return c.JSONBlob(http.StatusOK, body)
@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultGzipMinSize : smallest response body compressed when GZIP_MIN_SIZE
+// is not set
+const DefaultGzipMinSize = 1024
+
+// uncompressedPaths : paths whose responses are never compressed
+var uncompressedPaths = []string{"/healthz"}
+
+// gzipWriter holds the response status and body back until it is known
+// whether they are worth compressing
+type gzipWriter struct {
+	*bytes.Buffer
+	http.ResponseWriter
+	status int
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	return w.Buffer.Write(b)
+}
+
+// acceptsGzip : checks if the request accepts gzip encoded responses
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(strings.SplitN(enc, ";", 2)[0])
+		if enc == "gzip" || enc == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// compress : middleware gzipping the responses larger than GZIP_MIN_SIZE
+// to the clients accepting it
+func compress(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		for _, p := range uncompressedPaths {
+			if strings.HasSuffix(req.URL.Path, p) {
+				return next(c)
+			}
+		}
+
+		c.Response().Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req) {
+			return next(c)
+		}
+
+		w := c.Response().Writer
+		gw := &gzipWriter{Buffer: new(bytes.Buffer), ResponseWriter: w}
+		c.Response().Writer = gw
+
+		err := next(c)
+
+		c.Response().Writer = w
+		if gw.status == 0 && gw.Len() == 0 {
+			// nothing was written, the error handler will respond
+			return err
+		}
+		if gw.status == 0 {
+			gw.status = http.StatusOK
+		}
+
+		body := gw.Bytes()
+		header := w.Header()
+		if gw.Len() >= envPositiveInt("GZIP_MIN_SIZE", DefaultGzipMinSize) && header.Get("Content-Encoding") == "" {
+			compressed := new(bytes.Buffer)
+			zw := gzip.NewWriter(compressed)
+			if _, zerr := zw.Write(body); zerr == nil && zw.Close() == nil {
+				body = compressed.Bytes()
+				header.Set("Content-Encoding", "gzip")
+				header.Del("Content-Length")
+			}
+		}
+
+		w.WriteHeader(gw.status)
+		if _, werr := w.Write(body); werr != nil {
+			return werr
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,106 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompress(t *testing.T) {
+	testsSetup()
+	setup()
+
+	Convey("Scenario: compressing large responses", t, func() {
+		Convey("Given a large list of datacenters exists on the store", func() {
+			var ds []Datacenter
+			for i := 1; i <= 100; i++ {
+				ds = append(ds, Datacenter{ID: i, Name: "datacenter-" + strconv.Itoa(i), Type: "aws", GroupID: 1})
+			}
+			data, _ := json.Marshal(ds)
+			foundSubscriber("datacenter.find", string(data), 1)
+			h := handle(compress(getDatacentersHandler))
+
+			Convey("When I request it accepting gzip", func() {
+				rec, err := doRequestRecorder("GET", "/datacenters/?enrich=false", nil, nil, h, nil, map[string]string{"Accept-Encoding": "gzip, deflate"})
+
+				Convey("Then it should be returned gzipped", func() {
+					So(err, ShouldBeNil)
+					So(rec.Code, ShouldEqual, 200)
+					So(rec.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+					So(rec.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+
+					zr, err := gzip.NewReader(rec.Body)
+					So(err, ShouldBeNil)
+					body, err := ioutil.ReadAll(zr)
+					So(err, ShouldBeNil)
+
+					var d []Datacenter
+					So(json.Unmarshal(body, &d), ShouldBeNil)
+					So(len(d), ShouldEqual, 100)
+					So(d[99].Name, ShouldEqual, "datacenter-100")
+				})
+			})
+
+			Convey("When I request it without accepting gzip", func() {
+				rec, err := doRequestRecorder("GET", "/datacenters/?enrich=false", nil, nil, h, nil, nil)
+
+				Convey("Then it should be returned as is", func() {
+					So(err, ShouldBeNil)
+					So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+					So(rec.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+
+					var d []Datacenter
+					So(json.Unmarshal(rec.Body.Bytes(), &d), ShouldBeNil)
+					So(len(d), ShouldEqual, 100)
+				})
+			})
+		})
+
+		Convey("Given a small response", func() {
+			findDatacenterSubscriber(1)
+
+			Convey("When I request it accepting gzip", func() {
+				rec, err := doRequestRecorder("GET", "/datacenters/?enrich=false", nil, nil, handle(compress(getDatacentersHandler)), nil, map[string]string{"Accept-Encoding": "gzip"})
+
+				Convey("Then it should not be compressed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Code, ShouldEqual, 200)
+					So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+
+					var d []Datacenter
+					So(json.Unmarshal(rec.Body.Bytes(), &d), ShouldBeNil)
+					So(len(d), ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("Given the health probe", func() {
+			e := echo.New()
+			e.Use(compress)
+			setupServer(e)
+
+			Convey("When it is requested accepting gzip", func() {
+				req, _ := http.NewRequest("GET", "/healthz", nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+
+				Convey("Then it should not be compressed", func() {
+					So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+					So(rec.Header().Get("Vary"), ShouldEqual, "")
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// EffectiveConfig holds the non secret configuration the gateway is running
+// with, as read from the environment
+type EffectiveConfig struct {
+	RoutePrefix         string            `json:"route_prefix"`
+	ProbesWithoutPrefix bool              `json:"probes_without_prefix"`
+	TLS                 bool              `json:"tls"`
+	TLSMinVersion       string            `json:"tls_min_version,omitempty"`
+	NatsURI             string            `json:"nats_uri"`
+	NatsAuth            []string          `json:"nats_auth"`
+	NatsTLS             bool              `json:"nats_tls"`
+	NatsMaxPayload      int64             `json:"nats_max_payload"`
+	NatsRequestTimeout  string            `json:"nats_request_timeout"`
+	JWTAlgorithms       []string          `json:"jwt_algorithms"`
+	JWTExpSoft          bool              `json:"jwt_exp_soft"`
+	JWTRequireExp       bool              `json:"jwt_require_exp"`
+	JWTIssuer           string            `json:"jwt_issuer,omitempty"`
+	JWTAudience         string            `json:"jwt_audience,omitempty"`
+	RequireGroup        bool              `json:"require_group"`
+	BootstrapAdminKey   bool              `json:"bootstrap_admin_key"`
+	EncryptionKey       bool              `json:"encryption_key"`
+	APIKeys             []string          `json:"api_keys"`
+	RateLimit           float64           `json:"rate_limit"`
+	RateLimitBurst      float64           `json:"rate_limit_burst"`
+	Debug               bool              `json:"debug"`
+	LogFormat           string            `json:"log_format"`
+	BodyLogPaths        []string          `json:"body_log_paths"`
+	ValidateConcurrency int               `json:"validate_concurrency"`
+	ValidateTimeout     int               `json:"validate_timeout"`
+	ValidateDeadline    int               `json:"validate_deadline"`
+	ReconcileInterval   int               `json:"reconcile_interval"`
+	RequiredTags        []string          `json:"required_datacenter_tags"`
+	RestrictedTypes     []string          `json:"restricted_datacenter_types"`
+	DeprecatedRoutes    map[string]string `json:"deprecated_routes"`
+	CORSAllowedOrigins  []string          `json:"cors_allowed_origins"`
+	DrainURL            string            `json:"datacenter_drain_url"`
+	TraceExporterURL    string            `json:"trace_exporter_url"`
+	ShutdownGrace       string            `json:"shutdown_grace_period"`
+	MaxBodyBytes        int               `json:"max_body_bytes"`
+	GzipMinSize         int               `json:"gzip_min_size"`
+	DuplicateAccessKeys string            `json:"duplicate_credentials,omitempty"`
+}
+
+// maskURL : hides the credentials of the given url, if any
+func maskURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "***"
+	}
+	if u.User == nil {
+		return u.String()
+	}
+	// url.User would escape the mask as %2A%2A%2A
+	u.User = nil
+	return strings.Replace(u.String(), "//", "//***@", 1)
+}
+
+// envList : returns the non empty values of the given comma separated env
+// var
+func envList(name string) []string {
+	list := []string{}
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// natsAuth : returns the names of the NATS auth methods configured
+func natsAuth() []string {
+	methods := []string{}
+	if os.Getenv("NATS_TOKEN") != "" {
+		methods = append(methods, "token")
+	}
+	if os.Getenv("NATS_USER") != "" {
+		methods = append(methods, "user")
+	}
+	if os.Getenv("NATS_NKEY_SEED_FILE") != "" {
+		methods = append(methods, "nkey")
+	}
+	if os.Getenv("NATS_CREDS_FILE") != "" {
+		methods = append(methods, "creds")
+	}
+	return methods
+}
+
+// effectiveConfig : reads the current configuration leaving out any secret
+func effectiveConfig() EffectiveConfig {
+	keys := []string{}
+	for name := range apiKeys() {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	rate, burst := rateLimitConfig()
+
+	return EffectiveConfig{
+		RoutePrefix:         routePrefix(),
+		ProbesWithoutPrefix: probesWithoutPrefix(),
+		TLS:                 os.Getenv("TLS_CERT_FILE") != "" && os.Getenv("TLS_KEY_FILE") != "",
+		TLSMinVersion:       os.Getenv("TLS_MIN_VERSION"),
+		NatsURI:             maskURL(os.Getenv("NATS_URI")),
+		NatsAuth:            natsAuth(),
+		NatsTLS:             os.Getenv("NATS_TLS_CA") != "" || os.Getenv("NATS_TLS_CERT") != "",
+		NatsMaxPayload:      maxPayload(),
+		NatsRequestTimeout:  requestTimeout().String(),
+		JWTAlgorithms:       jwtAlgorithms(),
+		JWTExpSoft:          os.Getenv("JWT_EXP_SOFT") == "true",
+		JWTRequireExp:       os.Getenv("JWT_REQUIRE_EXP") == "true",
+		JWTIssuer:           os.Getenv("JWT_ISSUER"),
+		JWTAudience:         os.Getenv("JWT_AUDIENCE"),
+		RequireGroup:        os.Getenv("REQUIRE_GROUP") == "true",
+		BootstrapAdminKey:   os.Getenv("BOOTSTRAP_ADMIN_KEY") != "",
+		EncryptionKey:       encryptionEnabled(),
+		APIKeys:             keys,
+		RateLimit:           rate,
+		RateLimitBurst:      burst,
+		Debug:               os.Getenv("DEBUG") == "true",
+		LogFormat:           logFormat(),
+		BodyLogPaths:        envList("BODY_LOG_PATHS"),
+		ValidateConcurrency: envPositiveInt("VALIDATE_CONCURRENCY", DefaultValidateConcurrency),
+		ValidateTimeout:     envPositiveInt("VALIDATE_TIMEOUT", DefaultVerifyTimeout),
+		ValidateDeadline:    envPositiveInt("VALIDATE_DEADLINE", DefaultValidateDeadline),
+		ReconcileInterval:   envPositiveInt("RECONCILE_INTERVAL", 0),
+		RequiredTags:        envList("REQUIRED_DATACENTER_TAGS"),
+		RestrictedTypes:     envList("RESTRICTED_DATACENTER_TYPES"),
+		DeprecatedRoutes:    deprecatedRoutes(),
+		CORSAllowedOrigins:  envList("CORS_ALLOWED_ORIGINS"),
+		DrainURL:            drainURL(),
+		TraceExporterURL:    maskURL(os.Getenv("TRACE_EXPORTER_URL")),
+		ShutdownGrace:       shutdownGrace().String(),
+		MaxBodyBytes:        envPositiveInt("MAX_BODY_BYTES", DefaultMaxBodyBytes),
+		GzipMinSize:         envPositiveInt("GZIP_MIN_SIZE", DefaultGzipMinSize),
+		DuplicateAccessKeys: duplicateCredentialsMode(),
+	}
+}
+
+// getAdminConfigHandler : responds to GET /admin/config with the effective
+// configuration of the gateway
+func getAdminConfigHandler(c echo.Context) error {
+	au := authenticatedUser(c)
+	if au.Admin != true {
+		return ErrUnauthorized
+	}
+
+	return c.JSON(http.StatusOK, effectiveConfig())
+}
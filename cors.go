@@ -0,0 +1,71 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultCORSMethods : methods allowed to browser clients when
+// CORS_ALLOWED_METHODS is not set
+const DefaultCORSMethods = "GET,POST,PUT,PATCH,DELETE"
+
+// DefaultCORSHeaders : request headers allowed to browser clients when
+// CORS_ALLOWED_HEADERS is not set
+const DefaultCORSHeaders = "Authorization,Content-Type"
+
+// corsAllowed : checks if the origin is listed on CORS_ALLOWED_ORIGINS, no
+// origin is allowed when it is not set
+func corsAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, o := range envList("CORS_ALLOWED_ORIGINS") {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// envOr : returns the value of the env var or the default one when unset
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// cors : middleware adding the Access-Control-* headers to the requests
+// of the origins listed on CORS_ALLOWED_ORIGINS. Preflight requests are
+// answered with 204 without reaching the routes
+func cors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		origin := req.Header.Get("Origin")
+		header := c.Response().Header()
+		header.Add("Vary", "Origin")
+
+		if !corsAllowed(origin) {
+			return next(c)
+		}
+		header.Set("Access-Control-Allow-Origin", origin)
+
+		if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+			return next(c)
+		}
+
+		header.Set("Access-Control-Allow-Methods", envOr("CORS_ALLOWED_METHODS", DefaultCORSMethods))
+		header.Set("Access-Control-Allow-Headers", envOr("CORS_ALLOWED_HEADERS", DefaultCORSHeaders))
+		if age := os.Getenv("CORS_MAX_AGE"); age != "" {
+			header.Set("Access-Control-Max-Age", age)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
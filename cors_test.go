@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCORS(t *testing.T) {
+	h := handle(cors(okHandler))
+	preflight := map[string]string{
+		"Origin":                        "https://dashboard.example.com",
+		"Access-Control-Request-Method": "PUT",
+	}
+
+	Convey("Scenario: serving browser clients of other origins", t, func() {
+		Convey("Given the dashboard origin is allowed", func() {
+			_ = os.Setenv("CORS_ALLOWED_ORIGINS", "https://dashboard.example.com")
+
+			Convey("When the browser sends a preflight request", func() {
+				rec, err := doRequestRecorder("OPTIONS", "/api/datacenters/1", nil, nil, h, nil, preflight)
+
+				Convey("Then it should be answered without reaching the handler", func() {
+					So(err, ShouldBeNil)
+					So(rec.Code, ShouldEqual, 204)
+					So(rec.Body.String(), ShouldEqual, "")
+					So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://dashboard.example.com")
+					So(rec.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, DefaultCORSMethods)
+					So(rec.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, DefaultCORSHeaders)
+				})
+			})
+
+			Convey("When the browser sends the actual request", func() {
+				headers := map[string]string{"Origin": "https://dashboard.example.com"}
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+
+				Convey("Then it should be served with the origin allowed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+					So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://dashboard.example.com")
+				})
+			})
+
+			Convey("When another origin sends a request", func() {
+				headers := map[string]string{"Origin": "https://evil.example.com"}
+				rec, _ := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+
+				Convey("Then the origin should not be allowed", func() {
+					So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "")
+				})
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("CORS_ALLOWED_ORIGINS")
+			})
+		})
+
+		Convey("Given no origins are configured", func() {
+			rec, _ := doRequestRecorder("OPTIONS", "/api/datacenters/1", nil, nil, h, nil, preflight)
+
+			Convey("Then every origin should be denied", func() {
+				So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "")
+				So(rec.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "")
+			})
+		})
+	})
+}
@@ -0,0 +1,215 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// CredentialsOK : the provider accepted the datacenter credentials
+	CredentialsOK = "ok"
+	// CredentialsError : the provider rejected the datacenter credentials
+	CredentialsError = "error"
+	// CredentialsUnknown : the datacenter credentials were not verified yet
+	CredentialsUnknown = "unknown"
+)
+
+const (
+	// DefaultValidateConcurrency : datacenters verified at once by a
+	// validation sweep when VALIDATE_CONCURRENCY is not set
+	DefaultValidateConcurrency = 4
+	// DefaultVerifyTimeout : seconds to wait for each datacenter.verify
+	// response when VALIDATE_TIMEOUT is not set
+	DefaultVerifyTimeout = 5
+	// DefaultValidateDeadline : seconds a validation sweep can take before
+	// returning partial results when VALIDATE_DEADLINE is not set
+	DefaultValidateDeadline = 30
+)
+
+// CredentialsVerification holds the datacenter.verify response
+type CredentialsVerification struct {
+	Reachable bool   `json:"reachable"`
+	Message   string `json:"message"`
+}
+
+var credentialStatuses = &credentialCache{statuses: make(map[int]string)}
+
+// credentialCache keeps in memory the last verification status of each
+// datacenter credentials
+type credentialCache struct {
+	sync.Mutex
+	statuses map[int]string
+}
+
+// Get : returns the cached status for the datacenter, unknown if it was
+// never verified
+func (c *credentialCache) Get(id int) string {
+	c.Lock()
+	defer c.Unlock()
+
+	if status, ok := c.statuses[id]; ok {
+		return status
+	}
+	return CredentialsUnknown
+}
+
+// Set : caches the status for the datacenter
+func (c *credentialCache) Set(id int, status string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.statuses[id] = status
+}
+
+// Delete : forgets the cached status of the datacenter
+func (c *credentialCache) Delete(id int) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.statuses, id)
+}
+
+// IDs : returns the datacenters with a cached status
+func (c *credentialCache) IDs() []int {
+	c.Lock()
+	defer c.Unlock()
+
+	ids := []int{}
+	for id := range c.statuses {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// verifyCredentials : asks the provider connectors to verify the datacenter
+// credentials over datacenter.verify and caches the result
+func verifyCredentials(ctx context.Context, d Datacenter, timeout time.Duration) (v CredentialsVerification, err error) {
+	for _, c := range d.credentials() {
+		*c, _ = decryptCredential(*c)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return v, err
+	}
+
+	msg, err := request(ctx, "datacenter.verify", data, timeout)
+	if err == errNatsDisconnected {
+		return v, ErrServiceUnavailable
+	}
+	if err != nil {
+		log.Println(err)
+		return v, ErrGatewayTimeout
+	}
+
+	if err = json.Unmarshal(msg.Data, &v); err != nil {
+		return v, ErrInternal
+	}
+
+	if v.Reachable {
+		credentialStatuses.Set(d.ID, CredentialsOK)
+	} else {
+		credentialStatuses.Set(d.ID, CredentialsError)
+	}
+
+	return v, nil
+}
+
+// refreshCredentialStatus : verifies the datacenter credentials on the
+// background so its cached status is kept up to date
+func refreshCredentialStatus(d Datacenter) {
+	go func() {
+		if _, err := verifyCredentials(context.Background(), d, DefaultVerifyTimeout*time.Second); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+// DatacenterValidation holds the credentials verification result of a
+// datacenter on a validation sweep
+type DatacenterValidation struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ValidationSweep holds the results of a validation sweep, incomplete when
+// its deadline was exceeded before all datacenters were verified
+type ValidationSweep struct {
+	Results  []DatacenterValidation `json:"results"`
+	Complete bool                   `json:"complete"`
+}
+
+// envPositiveInt : returns the positive integer configured on the given env
+// var, or the default one
+func envPositiveInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// validateCredentials : verifies the datacenter credentials waiting up to
+// timeout for the provider connectors
+func validateCredentials(ctx context.Context, d Datacenter, timeout time.Duration) DatacenterValidation {
+	r := DatacenterValidation{ID: d.ID, Name: d.Name, Status: CredentialsOK}
+
+	v, err := verifyCredentials(ctx, d, timeout)
+	if err != nil {
+		r.Status = CredentialsUnknown
+		r.Message = err.Error()
+	} else if !v.Reachable {
+		r.Status = CredentialsError
+		r.Message = v.Message
+	}
+
+	return r
+}
+
+// validateAll : verifies the datacenters running at most concurrency
+// verifications at once, returning the results gathered so far when the
+// deadline is exceeded
+func validateAll(datacenters []Datacenter, concurrency int, deadline time.Duration, verify func(Datacenter) DatacenterValidation) (sweep ValidationSweep) {
+	results := make(chan DatacenterValidation, len(datacenters))
+	slots := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for _, d := range datacenters {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(d Datacenter) {
+				defer func() { <-slots }()
+				results <- verify(d)
+			}(d)
+		}
+	}()
+
+	expired := time.After(deadline)
+	sweep.Results = []DatacenterValidation{}
+	for len(sweep.Results) < len(datacenters) {
+		select {
+		case r := <-results:
+			sweep.Results = append(sweep.Results, r)
+		case <-expired:
+			return sweep
+		}
+	}
+	sweep.Complete = true
+
+	return sweep
+}
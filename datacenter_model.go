@@ -5,55 +5,344 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	aes "github.com/ernestio/crypto/aes"
 	"github.com/labstack/echo"
+	"github.com/nu7hatch/gouuid"
 )
 
 // Datacenter holds the datacenter response from datacenter-store
 type Datacenter struct {
-	ID              int    `json:"id"`
-	GroupID         int    `json:"group_id"`
-	GroupName       string `json:"group_name"`
-	Name            string `json:"name"`
-	Type            string `json:"type"`
-	Region          string `json:"region"`
-	Username        string `json:"username"`
-	Password        string `json:"password"`
-	VCloudURL       string `json:"vcloud_url"`
-	VseURL          string `json:"vse_url"`
-	ExternalNetwork string `json:"external_network"`
-	AccessKeyID     string `json:"aws_access_key_id,omitempty"`
-	SecretAccessKey string `json:"aws_secret_access_key,omitempty"`
-}
-
-// Validate the datacenter
+	ID              int               `json:"id"`
+	GroupID         int               `json:"group_id"`
+	GroupName       string            `json:"group_name"`
+	Name            string            `json:"name"`
+	Type            string            `json:"type"`
+	Region          string            `json:"region"`
+	Username        string            `json:"username"`
+	Password        string            `json:"password"`
+	VCloudURL       string            `json:"vcloud_url"`
+	VseURL          string            `json:"vse_url"`
+	ExternalNetwork string            `json:"external_network"`
+	AccessKeyID     string            `json:"aws_access_key_id,omitempty"`
+	SecretAccessKey string            `json:"aws_secret_access_key,omitempty"`
+	SubscriptionID  string            `json:"azure_subscription_id,omitempty"`
+	ClientID        string            `json:"azure_client_id,omitempty"`
+	ClientSecret    string            `json:"azure_client_secret,omitempty"`
+	TenantID        string            `json:"azure_tenant_id,omitempty"`
+	ExternalID      string            `json:"external_id"`
+	CreatedAt       time.Time         `json:"created_at"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	// CredentialExpiry is when the datacenter credentials stop being valid,
+	// for providers issuing expiring secrets
+	CredentialExpiry *time.Time `json:"credential_expiry,omitempty"`
+	// CredentialsEncrypted reports whether the stored credentials are
+	// encrypted, it is computed and never persisted by the gateway
+	CredentialsEncrypted bool    `json:"credentials_encrypted"`
+	Enabled              *bool   `json:"enabled,omitempty"`
+	CredentialStatus     string  `json:"credential_status,omitempty"`
+	Health               *Health `json:"health,omitempty"`
+	ProviderVersion      string  `json:"provider_version,omitempty"`
+	// ProviderRegion and CredentialsConfigured are derived on Improve so
+	// clients can tell which datacenters are ready to use without reading
+	// secrets, unlike CredentialStatus it doesn't tell whether they work
+	ProviderRegion        string `json:"provider_region,omitempty"`
+	CredentialsConfigured string `json:"credentials_configured,omitempty"`
+	// ProjectID and ServiceAccountJSON identify gcp datacenters, the
+	// latter holding the service account key document
+	ProjectID          string `json:"gcp_project_id,omitempty"`
+	ServiceAccountJSON string `json:"gcp_service_account_json,omitempty"`
+	// Deleted and DeletedAt are set on soft deletes, which keep the
+	// datacenter on the store
+	Deleted   bool       `json:"deleted,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// CredentialFingerprint identifies the aws access key id without its
+	// plaintext, so datacenters sharing it can be found even if encrypted
+	CredentialFingerprint string `json:"credential_fingerprint,omitempty"`
+	// encryptedAtRest is set on Decrypt when any credential was found
+	// encrypted, so it is never saved back as plaintext
+	encryptedAtRest bool
+}
+
+// ProviderVersionTimeout : maximum time to wait for the provider version,
+// it is kept short as it only enriches the datacenter
+const ProviderVersionTimeout = time.Second
+
+// DatacenterCountTimeout : maximum time to wait for the store to count the
+// datacenters before counting the find result instead
+const DatacenterCountTimeout = time.Second
+
+// DatacenterTypeCount holds how many datacenters of a given type exist
+type DatacenterTypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// DatacenterPage holds a page of datacenters and the cursor to the next one
+type DatacenterPage struct {
+	Datacenters []Datacenter `json:"datacenters"`
+	NextCursor  string       `json:"next_cursor"`
+}
+
+// DatacenterEnableResult holds the result of enabling or disabling a
+// datacenter on a bulk request
+type DatacenterEnableResult struct {
+	ID      int    `json:"id"`
+	Enabled bool   `json:"enabled"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DatacenterImpact holds what would be affected by deleting a datacenter
+type DatacenterImpact struct {
+	Services     int            `json:"services"`
+	Dependencies map[string]int `json:"dependencies"`
+}
+
+// immutableFields : datacenter fields which can't be patched as they are
+// owned by the store or computed by the gateway
+var immutableFields = []string{"id", "group_id", "group_name", "created_at", "credentials_encrypted", "credential_status", "health", "provider_version", "provider_region", "credentials_configured", "deleted", "deleted_at", "credential_fingerprint"}
+
+// DatacenterRenameCheck holds whether a datacenter can be safely renamed
+// and the services referring to it which would be affected
+type DatacenterRenameCheck struct {
+	Name             string   `json:"name"`
+	Safe             bool     `json:"safe"`
+	AffectedServices []string `json:"affected_services"`
+}
+
+// DatacenterImportReport holds the validation result of an import entry
+type DatacenterImportReport struct {
+	Index         int      `json:"index"`
+	Name          string   `json:"name"`
+	Valid         bool     `json:"valid"`
+	Errors        []string `json:"errors,omitempty"`
+	ConflictingID int      `json:"conflicting_id,omitempty"`
+}
+
+// DatacenterCreateResult holds the outcome of each datacenter of a bulk
+// creation
+type DatacenterCreateResult struct {
+	Index         int    `json:"index"`
+	ID            int    `json:"id,omitempty"`
+	Name          string `json:"name"`
+	Error         string `json:"error,omitempty"`
+	ConflictingID int    `json:"conflicting_id,omitempty"`
+}
+
+// DatacenterConflict holds the existing datacenter a request conflicts
+// with, sent as the details of the error
+type DatacenterConflict struct {
+	ConflictingID int `json:"conflicting_id"`
+}
+
+// DatacenterCredentials holds the credentials accepted when rotating them,
+// named as on the datacenter
+type DatacenterCredentials struct {
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	AccessKeyID        string `json:"aws_access_key_id"`
+	SecretAccessKey    string `json:"aws_secret_access_key"`
+	ClientSecret       string `json:"azure_client_secret"`
+	ServiceAccountJSON string `json:"gcp_service_account_json"`
+}
+
+// DatacenterInUse holds the reason a datacenter can't be deleted and the
+// action to take before retrying, sent as the details of the error
+type DatacenterInUse struct {
+	Reason string `json:"reason"`
+	Hint   string `json:"hint"`
+	Drain  string `json:"drain"`
+}
+
+// DefaultDrainURL : endpoint used to move services out of a datacenter
+const DefaultDrainURL = "/services/bulk-move/"
+
+// drainURL : endpoint suggested to drain a datacenter, configurable with
+// DATACENTER_DRAIN_URL
+func drainURL() string {
+	if url := os.Getenv("DATACENTER_DRAIN_URL"); url != "" {
+		return url
+	}
+	return DefaultDrainURL
+}
+
+// Validate the datacenter, requiring the credentials of its type
 func (d *Datacenter) Validate() error {
+	if err := d.validateName(); err != nil {
+		return err
+	}
+
+	return d.validateFields()
+}
+
+// ValidateUpdate : validates the datacenter updated from the existing one,
+// checking the name format only when it changed so datacenters named
+// before it was enforced can still be updated
+func (d *Datacenter) ValidateUpdate(existing Datacenter) error {
+	if d.Name == "" || d.Name != existing.Name {
+		if err := d.validateName(); err != nil {
+			return err
+		}
+	}
+
+	return d.validateFields()
+}
+
+// validateName : requires a DNS safe datacenter name
+func (d *Datacenter) validateName() error {
 	if d.Name == "" {
-		return errors.New("Datacenter name is empty")
+		return ValidationError{Code: "datacenter.name.empty"}
 	}
 
+	if !validDatacenterName(d.Name) {
+		return ValidationError{Code: "datacenter.name.invalid"}
+	}
+
+	return nil
+}
+
+// validateFields : requires the type of the datacenter and its credentials
+func (d *Datacenter) validateFields() error {
 	if d.Type == "" {
-		return errors.New("Datacenter type is empty")
+		return ValidationError{Code: "datacenter.type.empty"}
+	}
+
+	switch d.Type {
+	case "aws":
+		if d.AccessKeyID == "" {
+			return ValidationError{Code: "datacenter.aws_access_key_id.empty"}
+		}
+		if d.SecretAccessKey == "" {
+			return ValidationError{Code: "datacenter.aws_secret_access_key.empty"}
+		}
+	case "azure":
+		if d.SubscriptionID == "" {
+			return ValidationError{Code: "datacenter.azure_subscription_id.empty"}
+		}
+		if d.ClientID == "" {
+			return ValidationError{Code: "datacenter.azure_client_id.empty"}
+		}
+		if d.ClientSecret == "" {
+			return ValidationError{Code: "datacenter.azure_client_secret.empty"}
+		}
+		if d.TenantID == "" {
+			return ValidationError{Code: "datacenter.azure_tenant_id.empty"}
+		}
+	case "gcp":
+		if d.ProjectID == "" {
+			return ValidationError{Code: "datacenter.gcp_project_id.empty"}
+		}
+		if d.ServiceAccountJSON == "" {
+			return ValidationError{Code: "datacenter.gcp_service_account_json.empty"}
+		}
+		if !isServiceAccountKey(d.ServiceAccountJSON) {
+			return ValidationError{Code: "datacenter.gcp_service_account_json.invalid"}
+		}
+	case "vcloud":
+		if d.Username == "" {
+			return ValidationError{Code: "datacenter.username.empty"}
+		}
+		if d.Password == "" {
+			return ValidationError{Code: "datacenter.password.empty"}
+		}
+		if d.VCloudURL == "" {
+			return ValidationError{Code: "datacenter.vcloud_url.empty"}
+		}
+	default:
+		return ValidationError{Code: "datacenter.type.unsupported"}
+	}
+
+	return d.ValidateTags()
+}
+
+// datacenterNameFormat : matches DNS safe datacenter names
+var datacenterNameFormat = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// validDatacenterName : checks the name is DNS safe, from 3 to 63 lowercase
+// letters, digits or hyphens not starting nor ending with a hyphen
+func validDatacenterName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	if strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+		return false
 	}
+	return datacenterNameFormat.MatchString(name)
+}
 
-	if d.Username == "" {
-		return errors.New("Datacenter username is empty")
+// isServiceAccountKey : checks the given json document is a gcp service
+// account key
+func isServiceAccountKey(document string) bool {
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal([]byte(document), &key); err != nil {
+		return false
 	}
 
-	if d.Type == "vcloud" && d.VCloudURL == "" {
-		return errors.New("Datacenter vcloud url is empty")
+	return key.Type == "service_account" && key.ClientEmail != "" && key.PrivateKey != ""
+}
+
+// ValidateTags : checks the datacenter has a non empty value for each of
+// the tag keys listed on REQUIRED_DATACENTER_TAGS
+func (d *Datacenter) ValidateTags() error {
+	for _, key := range strings.Split(os.Getenv("REQUIRED_DATACENTER_TAGS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && d.Tags[key] == "" {
+			return ValidationError{Code: "datacenter.tags.missing"}
+		}
 	}
 
 	return nil
 }
 
-// Map : maps a datacenter from a request's body and validates the input
+// errNoUniqueName : returned when every generated name is already taken
+var errNoUniqueName = errors.New("Could not generate a unique datacenter name")
+
+// GenerateName : assigns a unique name to the datacenter composed by its
+// type and a random suffix
+func (d *Datacenter) GenerateName(ctx context.Context) error {
+	var existing Datacenter
+
+	for i := 0; i < 5; i++ {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+
+		var named []Datacenter
+		name := d.Type + "-" + id.String()[:8]
+		if err := existing.FindBy(ctx, map[string]interface{}{"name": name}, &named); err != nil {
+			return err
+		}
+		if len(named) == 0 {
+			d.Name = name
+			return nil
+		}
+	}
+
+	return errNoUniqueName
+}
+
+// Map : maps a datacenter from a request's body and validates the input,
+// rejecting fields the authenticated user role can't write. Aws
+// datacenters without a region get the default region of the user's group
 func (d *Datacenter) Map(c echo.Context) *echo.HTTPError {
 	body := c.Request().Body
 	data, err := ioutil.ReadAll(body)
@@ -61,106 +350,595 @@ func (d *Datacenter) Map(c echo.Context) *echo.HTTPError {
 		return ErrBadReqBody
 	}
 
-	err = json.Unmarshal(data, &d)
-	if err != nil {
+	return d.MapData(c.Request().Context(), authenticatedUser(c), data)
+}
+
+// MapData : maps the given json datacenter as written by the given user
+func (d *Datacenter) MapData(ctx context.Context, au User, data []byte) *echo.HTTPError {
+	if err := json.Unmarshal(data, &d); err != nil {
 		return ErrBadReqBody
 	}
 
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ErrBadReqBody
+	}
+	if he := checkWritable(au, fields); he != nil {
+		return he
+	}
+
+	if d.Type == "aws" && d.Region == "" {
+		g := au.Group(ctx)
+		d.Region = g.DefaultRegion
+	}
+
+	return nil
+}
+
+// checkWritable : rejects the given datacenter fields the user role can't
+// write
+func checkWritable(au User, fields map[string]interface{}) *echo.HTTPError {
+	acl, ok := datacenterFieldACL(au.Role())
+	if !ok {
+		return nil
+	}
+
+	for field := range fields {
+		if !acl.CanWrite(field) {
+			return echo.NewHTTPError(403, "Current user can't write the datacenter "+field)
+		}
+	}
+
+	return nil
+}
+
+// Patch : overwrites the datacenter fields present on the given ones,
+// leaving the rest intact
+func (d *Datacenter) Patch(fields map[string]interface{}) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]interface{})
+	if err = json.Unmarshal(data, &current); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		current[k] = v
+	}
+
+	if data, err = json.Marshal(current); err != nil {
+		return err
+	}
+
+	var patched Datacenter
+	if err = json.Unmarshal(data, &patched); err != nil {
+		return err
+	}
+	patched.encryptedAtRest = d.encryptedAtRest
+	*d = patched
+
+	return nil
+}
+
+// FindBy : Searches for all datacenters matching the given filters, an
+// empty list is returned when none matches. Credentials are returned
+// decrypted
+func (d *Datacenter) FindBy(ctx context.Context, filters map[string]interface{}, datacenters *[]Datacenter) (err error) {
+	if err := NewBaseModel("datacenter").FindBy(ctx, filters, datacenters); err != nil {
+		return err
+	}
+	if *datacenters == nil {
+		*datacenters = []Datacenter{}
+	}
+	for i := range *datacenters {
+		(*datacenters)[i].Decrypt()
+	}
 	return nil
 }
 
 // FindByName : Searches for all datacenters with a name equal to the specified
-func (d *Datacenter) FindByName(name string, datacenter *Datacenter) (err error) {
+func (d *Datacenter) FindByName(ctx context.Context, name string, datacenter *Datacenter) (err error) {
+	var datacenters []Datacenter
+
 	query := make(map[string]interface{})
 	query["name"] = name
-	if err := NewBaseModel("datacenter").GetBy(query, datacenter); err != nil {
+	if err := d.FindBy(ctx, query, &datacenters); err != nil {
 		return err
 	}
+	if len(datacenters) == 0 {
+		return errors.New(`"Specified datacenter does not exist"`)
+	}
+	*datacenter = datacenters[0]
 	return nil
 }
 
 // FindByGroupID : Searches for all datacenters on the store current user
 // has access to with the specified group id
-func (d *Datacenter) FindByGroupID(id int, datacenters *[]Datacenter) (err error) {
+func (d *Datacenter) FindByGroupID(ctx context.Context, id int, datacenters *[]Datacenter) (err error) {
 	query := make(map[string]interface{})
 	query["group_id"] = id
-	if err := NewBaseModel("datacenter").FindBy(query, datacenters); err != nil {
-		return err
-	}
-	return nil
+	return d.FindBy(ctx, query, datacenters)
 }
 
 // FindByNameAndGroupID : Searches for all datacenters with a name equal to the specified
-func (d *Datacenter) FindByNameAndGroupID(name string, id int, datacenters *[]Datacenter) (err error) {
+func (d *Datacenter) FindByNameAndGroupID(ctx context.Context, name string, id int, datacenters *[]Datacenter) (err error) {
 	query := make(map[string]interface{})
 	query["name"] = name
 	query["group_id"] = id
-	if err := NewBaseModel("datacenter").FindBy(query, datacenters); err != nil {
+	return d.FindBy(ctx, query, datacenters)
+}
+
+// FindByExternalID : Gets a model by its external reference id, scoped
+// to the given user's group unless the user is an admin
+func (d *Datacenter) FindByExternalID(ctx context.Context, id string, au User) (err error) {
+	query := make(map[string]interface{})
+	query["external_id"] = id
+	if !au.Admin {
+		query["group_id"] = au.GroupID
+	}
+	if err := NewBaseModel("datacenter").GetBy(ctx, query, d); err != nil {
 		return err
 	}
+	if d.Deleted {
+		return ErrNotFound
+	}
+	d.Decrypt()
+
 	return nil
 }
 
-// FindByID : Gets a model by its id
-func (d *Datacenter) FindByID(id int) (err error) {
+// FindByExternalIDAndGroupID : Searches for all datacenters with an external
+// id equal to the specified on the given group
+func (d *Datacenter) FindByExternalIDAndGroupID(ctx context.Context, id string, group int, datacenters *[]Datacenter) (err error) {
+	query := make(map[string]interface{})
+	query["external_id"] = id
+	query["group_id"] = group
+	return d.FindBy(ctx, query, datacenters)
+}
+
+// FindByCredentialFingerprint : Searches for all datacenters of the given
+// group whose aws access key id has the given fingerprint. Fingerprints are
+// computed when found, so datacenters stored without one are found too
+func (d *Datacenter) FindByCredentialFingerprint(ctx context.Context, fingerprint string, group int, datacenters *[]Datacenter) (err error) {
+	var found []Datacenter
+	if err = d.FindByGroupID(ctx, group, &found); err != nil {
+		return err
+	}
+
+	*datacenters = []Datacenter{}
+	for _, dc := range NotDeleted(found) {
+		if dc.CredentialFingerprint != "" && dc.CredentialFingerprint == fingerprint {
+			*datacenters = append(*datacenters, dc)
+		}
+	}
+	return nil
+}
+
+// FindByID : Gets a model by its id, soft deleted datacenters are not
+// found
+func (d *Datacenter) FindByID(ctx context.Context, id int) (err error) {
+	if err = d.FindByIDWithDeleted(ctx, id); err != nil {
+		return err
+	}
+	if d.Deleted {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// FindByIDWithDeleted : Gets a model by its id, even if soft deleted
+func (d *Datacenter) FindByIDWithDeleted(ctx context.Context, id int) (err error) {
 	query := make(map[string]interface{})
 	query["id"] = id
-	if err := NewBaseModel("datacenter").GetBy(query, d); err != nil {
+	if err := NewBaseModel("datacenter").GetBy(ctx, query, d); err != nil {
 		return err
 	}
+	d.Decrypt()
+
 	return nil
 }
 
 // FindAll : Searches for all groups on the store current user
 // has access to
-func (d *Datacenter) FindAll(au User, datacenters *[]Datacenter) (err error) {
+func (d *Datacenter) FindAll(ctx context.Context, au User, datacenters *[]Datacenter) (err error) {
+	return d.FindBy(ctx, make(map[string]interface{}), datacenters)
+}
+
+// Count : counts the datacenters the user has access to, all of them for
+// admins. It asks the store on datacenter.count and counts the datacenters
+// found when the store doesn't reply with a count
+func (d *Datacenter) Count(ctx context.Context, au User) (int, error) {
+	var req []byte
 	query := make(map[string]interface{})
-	if err := NewBaseModel("datacenter").FindBy(query, datacenters); err != nil {
-		return err
+	if !au.Admin {
+		query["group_id"] = au.GroupID
+		req, _ = json.Marshal(query)
 	}
-	return nil
+
+	msg, err := request(ctx, "datacenter.count", req, DatacenterCountTimeout)
+	if err == errNatsDisconnected {
+		return 0, ErrServiceUnavailable
+	}
+	if err == nil {
+		if re := responseErr(msg); re != nil {
+			return 0, re.HTTPError
+		}
+		var res struct {
+			Count *int `json:"count"`
+		}
+		if json.Unmarshal(msg.Data, &res) == nil && res.Count != nil {
+			return *res.Count, nil
+		}
+	}
+
+	var datacenters []Datacenter
+	if err := d.FindBy(ctx, query, &datacenters); err != nil {
+		return 0, err
+	}
+
+	return len(NotDeleted(datacenters)), nil
 }
 
-// Save : calls datacenter.set with the marshalled current group
-func (d *Datacenter) Save() (err error) {
-	if err := NewBaseModel("datacenter").Save(d); err != nil {
+// Save : calls datacenter.set with the marshalled current datacenter,
+// encrypting its credentials first when ENCRYPTION_KEY is set. The fields
+// computed by the gateway are cleared and never sent to the store
+func (d *Datacenter) Save(ctx context.Context) (err error) {
+	d.clearComputed()
+	d.CredentialFingerprint = credentialFingerprint(d.AccessKeyID)
+	if encryptionEnabled() {
+		if err := d.Encrypt(); err != nil {
+			return ErrInternal
+		}
+	} else if !d.Encrypted() {
+		if d.encryptedAtRest {
+			log.Println("ERROR: ENCRYPTION_KEY is not set, refusing to store the encrypted credentials of datacenter " + d.Name + " as plaintext")
+			return ErrServiceUnavailable
+		}
+		log.Println("WARNING: ENCRYPTION_KEY is not set, storing the credentials of datacenter " + d.Name + " as plaintext")
+	}
+	if err := NewBaseModel("datacenter").Save(ctx, d); err != nil {
 		return err
 	}
 	return nil
 }
 
+// clearComputed : clears the fields computed by the gateway when
+// responding
+func (d *Datacenter) clearComputed() {
+	d.CredentialStatus = ""
+	d.Health = nil
+	d.ProviderVersion = ""
+	d.ProviderRegion = ""
+	d.CredentialsConfigured = ""
+}
+
 // Delete : will delete a datacenter by its id
-func (d *Datacenter) Delete() (err error) {
+func (d *Datacenter) Delete(ctx context.Context) (err error) {
 	query := make(map[string]interface{})
 	query["id"] = d.ID
-	if err := NewBaseModel("datacenter").Delete(query); err != nil {
+	if err := NewBaseModel("datacenter").Delete(ctx, query); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Redact : removes all sensitive fields from the return
-// data before outputting to the user
-func (d *Datacenter) Redact() {
+// credentialFields : json names of the datacenter credentials, in the same
+// order as returned by credentials
+var credentialFields = []string{"username", "password", "aws_access_key_id", "aws_secret_access_key", "azure_client_secret", "gcp_service_account_json"}
+
+// credentials : returns the datacenter credential fields
+func (d *Datacenter) credentials() []*string {
+	return []*string{&d.Username, &d.Password, &d.AccessKeyID, &d.SecretAccessKey, &d.ClientSecret, &d.ServiceAccountJSON}
+}
+
+// encryptedCredentials : returns the credential fields stored encrypted
+// for the datacenter type, as configured on ENCRYPTED_FIELDS_<TYPE>, all of
+// them by default
+func (d *Datacenter) encryptedCredentials() []*string {
+	fields := d.credentials()
+
+	v := os.Getenv("ENCRYPTED_FIELDS_" + strings.ToUpper(d.Type))
+	if v == "" {
+		return fields
+	}
+
+	selected := []*string{}
+	for _, f := range strings.Split(v, ",") {
+		for i, name := range credentialFields {
+			if strings.TrimSpace(f) == name {
+				selected = append(selected, fields[i])
+			}
+		}
+	}
+
+	return selected
+}
+
+// credentialFingerprint : returns the sha256 of the given credential
+// plaintext, empty for empty credentials
+func credentialFingerprint(value string) string {
+	if value == "" {
+		return ""
+	}
+	v, _ := decryptCredential(value)
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
+
+// Encrypted : checks if none of the datacenter credentials are stored as
+// plaintext
+func (d *Datacenter) Encrypted() bool {
+	for _, c := range d.encryptedCredentials() {
+		if *c != "" && !isEncryptedCredential(*c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Encrypt : encrypts the datacenter credentials stored as plaintext or
+// with the legacy ERNEST_CRYPTO_KEY scheme
+func (d *Datacenter) Encrypt() (err error) {
+	for _, c := range d.encryptedCredentials() {
+		if *c == "" || isEncryptedCredential(*c) {
+			continue
+		}
+		plaintext, _ := decryptLegacyCredential(*c)
+		if *c, err = encryptCredential(plaintext); err != nil {
+			return err
+		}
+	}
+	d.CredentialsEncrypted = true
+
+	return nil
+}
+
+// Decrypt : replaces the encrypted datacenter credentials by their
+// plaintext, keeping whether they were encrypted at rest
+func (d *Datacenter) Decrypt() {
+	d.CredentialsEncrypted = d.Encrypted()
+	for _, c := range d.credentials() {
+		var encrypted bool
+		*c, encrypted = decryptCredential(*c)
+		d.encryptedAtRest = d.encryptedAtRest || encrypted
+	}
+	d.CredentialFingerprint = credentialFingerprint(d.AccessKeyID)
+}
+
+// ChangedCredentials : returns the json name of the credential fields
+// differing between the datacenter and the given one
+func (d *Datacenter) ChangedCredentials(other Datacenter) []string {
+	changed := []string{}
+	current := d.credentials()
+	incoming := other.credentials()
+
+	for i, name := range credentialFields {
+		a, _ := decryptCredential(*current[i])
+		b, _ := decryptCredential(*incoming[i])
+		if a != b {
+			changed = append(changed, name)
+		}
+	}
+
+	return changed
+}
+
+// FilledCredentials : returns the json name of the credential fields with
+// a value
+func (d *Datacenter) FilledCredentials() []string {
+	filled := []string{}
+	for i, c := range d.credentials() {
+		if *c != "" {
+			filled = append(filled, credentialFields[i])
+		}
+	}
+	return filled
+}
+
+// ChangedFields : returns the json name of the credentials, azure ids and
+// tags differing between the datacenter and the given one, as overwritten
+// on updates
+func (d *Datacenter) ChangedFields(other Datacenter) []string {
+	changed := d.ChangedCredentials(other)
+
+	ids := []struct{ name, current, incoming string }{
+		{"azure_subscription_id", d.SubscriptionID, other.SubscriptionID},
+		{"azure_client_id", d.ClientID, other.ClientID},
+		{"azure_tenant_id", d.TenantID, other.TenantID},
+	}
+	for _, id := range ids {
+		if id.current != id.incoming {
+			changed = append(changed, id.name)
+		}
+	}
+
+	if (len(d.Tags) > 0 || len(other.Tags) > 0) && !reflect.DeepEqual(d.Tags, other.Tags) {
+		changed = append(changed, "tags")
+	}
+
+	return changed
+}
+
+// fields : returns the given credentials by their json name
+func (cr DatacenterCredentials) fields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	for i, v := range []string{cr.Username, cr.Password, cr.AccessKeyID, cr.SecretAccessKey, cr.ClientSecret, cr.ServiceAccountJSON} {
+		if v != "" {
+			fields[credentialFields[i]] = v
+		}
+	}
+	return fields
+}
+
+// RotateCredentials : replaces the credentials used by the datacenter type
+// with the given ones, leaving any other field untouched
+func (d *Datacenter) RotateCredentials(cr DatacenterCredentials) {
+	switch d.Type {
+	case "aws":
+		d.AccessKeyID = cr.AccessKeyID
+		d.SecretAccessKey = cr.SecretAccessKey
+	case "azure":
+		d.ClientSecret = cr.ClientSecret
+	case "gcp":
+		d.ServiceAccountJSON = cr.ServiceAccountJSON
+	default:
+		d.Username = cr.Username
+		d.Password = cr.Password
+	}
+}
+
+// IsEnabled : checks if new services can be created on the datacenter,
+// datacenters are enabled unless explicitly disabled
+func (d *Datacenter) IsEnabled() bool {
+	return d.Enabled == nil || *d.Enabled
+}
+
+// datacenterCapabilities : service types each datacenter type can run,
+// any other datacenter type can't run services
+var datacenterCapabilities = map[string][]string{
+	"aws":    {"aws", "aws-fake"},
+	"azure":  {"azure", "azure-fake"},
+	"gcp":    {"gcp"},
+	"vcloud": {"vcloud", "vcloud-fake"},
+}
+
+// Supports : checks if services of the given type can run on the datacenter
+// as listed on its type capabilities
+func (d *Datacenter) Supports(serviceType string) bool {
+	for _, t := range datacenterCapabilities[d.Type] {
+		if t == serviceType {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact : removes all sensitive fields, and the ones the given user role
+// can't read, from the return data before outputting to the user
+func (d *Datacenter) Redact(au User) {
+	enabled := d.IsEnabled()
+	d.Enabled = &enabled
+	d.CredentialsEncrypted = d.CredentialsEncrypted || d.Encrypted()
+	d.CredentialStatus = credentialStatuses.Get(d.ID)
 	d.AccessKeyID = ""
 	d.SecretAccessKey = ""
-	crypto := aes.New()
-	key := os.Getenv("ERNEST_CRYPTO_KEY")
-	if d.Username != "" {
-		d.Username, _ = crypto.Decrypt(d.Username, key)
-	}
+	d.ClientSecret = ""
+	d.ServiceAccountJSON = ""
+	d.CredentialFingerprint = ""
+	d.Username, _ = decryptCredential(d.Username)
 	d.Password = ""
+
+	if acl, ok := datacenterFieldACL(au.Role()); ok {
+		clearUnreadable(d, acl)
+	}
+}
+
+// Improve : adds extra data as group name and provider version
+func (d *Datacenter) Improve(ctx context.Context) {
+	d.improve(ctx, time.Now().Add(ProviderVersionTimeout))
 }
 
-// Improve : adds extra data as group name
-func (d *Datacenter) Improve() {
-	g := d.Group()
+// improve : adds extra data as group name and the provider version, if
+// fetched before the given deadline
+func (d *Datacenter) improve(ctx context.Context, deadline time.Time) {
+	g := d.Group(ctx)
 	d.GroupName = g.Name
+	d.ProviderVersion = d.fetchProviderVersion(ctx, time.Until(deadline))
+	d.describeProvider()
+}
+
+// ImproveDatacenters : improves the given datacenters concurrently, so the
+// provider versions of the whole list are waited for ProviderVersionTimeout
+// at most instead of once per datacenter
+func ImproveDatacenters(ctx context.Context, datacenters []Datacenter) {
+	var wg sync.WaitGroup
+
+	deadline := time.Now().Add(ProviderVersionTimeout)
+	for i := range datacenters {
+		wg.Add(1)
+		go func(d *Datacenter) {
+			defer wg.Done()
+			d.improve(ctx, deadline)
+		}(&datacenters[i])
+	}
+	wg.Wait()
+}
+
+// describeProvider : sets the region of the datacenter provider and
+// whether the credentials its type requires are "set" or "missing"
+func (d *Datacenter) describeProvider() {
+	required := []string{d.Username, d.Password}
+	d.ProviderRegion = d.Region
+
+	switch d.Type {
+	case "aws":
+		required = []string{d.AccessKeyID, d.SecretAccessKey}
+	case "azure":
+		required = []string{d.SubscriptionID, d.ClientID, d.ClientSecret, d.TenantID}
+	case "gcp":
+		required = []string{d.ProjectID, d.ServiceAccountJSON}
+	case "vcloud":
+		if u, err := url.Parse(d.VCloudURL); err == nil {
+			d.ProviderRegion = u.Hostname()
+		}
+	}
+
+	d.CredentialsConfigured = "set"
+	for _, c := range required {
+		if c == "" {
+			d.CredentialsConfigured = "missing"
+		}
+	}
+}
+
+// FetchProviderVersion : asks the provider connectors on datacenter.version
+// for the api version of the datacenter provider
+func (d *Datacenter) FetchProviderVersion(ctx context.Context) string {
+	return d.fetchProviderVersion(ctx, ProviderVersionTimeout)
+}
+
+// fetchProviderVersion : asks for the provider version waiting the given
+// timeout at most, empty when it expired
+func (d *Datacenter) fetchProviderVersion(ctx context.Context, timeout time.Duration) string {
+	var v struct {
+		Version string `json:"version"`
+	}
+
+	query := make(map[string]interface{})
+	query["id"] = d.ID
+	query["type"] = d.Type
+	req, _ := json.Marshal(query)
+
+	if timeout <= 0 {
+		return ""
+	}
+	msg, err := request(ctx, "datacenter.version", req, timeout)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	if err := json.Unmarshal(msg.Data, &v); err != nil {
+		log.Println(err)
+	}
+
+	return v.Version
+}
+
+// CheckHealth : computes the datacenter health from its credential status,
+// enabled state and services
+func (d *Datacenter) CheckHealth(ctx context.Context) {
+	services, err := d.Services(ctx)
+	h := datacenterHealth(*d, services, err)
+	d.Health = &h
 }
 
 // Group : Gets the related datacenter group if any
-func (d *Datacenter) Group() (group Group) {
-	if err := group.FindByID(d.GroupID); err != nil {
+func (d *Datacenter) Group(ctx context.Context) (group Group) {
+	if err := group.FindByID(ctx, d.GroupID); err != nil {
 		log.Println(err)
 	}
 
@@ -168,9 +946,168 @@ func (d *Datacenter) Group() (group Group) {
 }
 
 // Services : Get the services related with current datacenter
-func (d *Datacenter) Services() (services []Service, err error) {
+func (d *Datacenter) Services(ctx context.Context) (services []Service, err error) {
 	var s Service
-	err = s.FindByDatacenterID(d.ID, &services)
+	err = s.FindByDatacenterID(ctx, d.ID, &services)
 
 	return services, err
 }
+
+// Impact : counts the services of the datacenter and, by type, the
+// resources they transitively depend on
+func (d *Datacenter) Impact(ctx context.Context) (impact DatacenterImpact, err error) {
+	services, err := d.Services(ctx)
+	if err != nil {
+		return impact, err
+	}
+
+	impact.Services = len(services)
+	impact.Dependencies = make(map[string]int)
+	for _, s := range services {
+		deps, err := s.Dependencies(ctx)
+		if err != nil {
+			return impact, err
+		}
+		for _, dep := range deps {
+			impact.Dependencies[dep.Type]++
+		}
+	}
+
+	return impact, nil
+}
+
+// TypesInUse : returns the distinct types of the given datacenters with
+// the number of datacenters for each one, sorted by type
+func TypesInUse(datacenters []Datacenter) []DatacenterTypeCount {
+	counts := make(map[string]int)
+	for _, d := range datacenters {
+		counts[d.Type]++
+	}
+
+	types := []DatacenterTypeCount{}
+	for t, c := range counts {
+		types = append(types, DatacenterTypeCount{Type: t, Count: c})
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Type < types[j].Type
+	})
+
+	return types
+}
+
+// CreatedBetween : returns the datacenters created within the given range,
+// a zero bound leaves that side of the range open
+func CreatedBetween(datacenters []Datacenter, after time.Time, before time.Time) []Datacenter {
+	filtered := []Datacenter{}
+	for _, d := range datacenters {
+		if !after.IsZero() && d.CreatedAt.Before(after) {
+			continue
+		}
+		if !before.IsZero() && d.CreatedAt.After(before) {
+			continue
+		}
+		filtered = append(filtered, d)
+	}
+
+	return filtered
+}
+
+// NotDeleted : returns the datacenters which haven't been soft deleted
+func NotDeleted(datacenters []Datacenter) []Datacenter {
+	filtered := []Datacenter{}
+	for _, d := range datacenters {
+		if !d.Deleted {
+			filtered = append(filtered, d)
+		}
+	}
+
+	return filtered
+}
+
+// ExpiringBefore : returns the datacenters whose credentials expire before
+// the given time, including the already expired ones
+func ExpiringBefore(datacenters []Datacenter, t time.Time) []Datacenter {
+	expiring := []Datacenter{}
+	for _, d := range datacenters {
+		if d.CredentialExpiry != nil && d.CredentialExpiry.Before(t) {
+			expiring = append(expiring, d)
+		}
+	}
+
+	return expiring
+}
+
+// datacenterSortFields : fields the datacenters can be sorted by
+var datacenterSortFields = []string{"id", "name", "type"}
+
+// SortDatacenters : sorts the datacenters by the given field, ties are
+// sorted by id
+func SortDatacenters(datacenters []Datacenter, field string, desc bool) {
+	less := func(a, b Datacenter) bool {
+		switch field {
+		case "name":
+			if x, y := strings.ToLower(a.Name), strings.ToLower(b.Name); x != y {
+				return x < y
+			}
+		case "type":
+			if a.Type != b.Type {
+				return a.Type < b.Type
+			}
+		}
+		return a.ID < b.ID
+	}
+
+	sort.Slice(datacenters, func(i, j int) bool {
+		if desc {
+			return less(datacenters[j], datacenters[i])
+		}
+		return less(datacenters[i], datacenters[j])
+	})
+}
+
+// SelectDatacenterFields : returns the given datacenters with only the
+// given json fields, ignoring the fields they don't have
+func SelectDatacenterFields(datacenters []Datacenter, fields []string) ([]map[string]interface{}, error) {
+	selected := make([]map[string]interface{}, 0, len(datacenters))
+	for _, d := range datacenters {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		all := make(map[string]interface{})
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+
+		s := make(map[string]interface{})
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				s[f] = v
+			}
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// PaginateDatacenters : returns up to limit datacenters sorted by id with an
+// id greater than after, and the cursor to the next page if any
+func PaginateDatacenters(datacenters []Datacenter, after int, limit int) (page DatacenterPage) {
+	sort.Slice(datacenters, func(i, j int) bool {
+		return datacenters[i].ID < datacenters[j].ID
+	})
+
+	page.Datacenters = []Datacenter{}
+	for _, d := range datacenters {
+		if d.ID <= after {
+			continue
+		}
+		if len(page.Datacenters) == limit {
+			page.NextCursor = encodeCursor(page.Datacenters[limit-1].ID)
+			break
+		}
+		page.Datacenters = append(page.Datacenters, d)
+	}
+
+	return page
+}
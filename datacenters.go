@@ -5,35 +5,388 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo"
 )
 
 // getDatacentersHandler : responds to GET /datacenters/ with a list of all
-// datacenters
+// datacenters, or with a page of them when a ?cursor= is given, optionally
+// limited to the ones of a ?type= and created within ?created_after= and
+// ?created_before=. Derived fields are skipped with ?enrich=false
 func getDatacentersHandler(c echo.Context) (err error) {
-	var datacenters []Datacenter
+	ctx := c.Request().Context()
 	var body []byte
-	var datacenter Datacenter
 
 	au := authenticatedUser(c)
+	datacenters, err := listDatacenters(c, au)
+	if err != nil {
+		return err
+	}
+
+	field, desc, err := sortOrder(c)
+	if err != nil {
+		return err
+	}
+
+	if usesCursor(c) {
+		if field != "id" || desc {
+			return echo.NewHTTPError(400, "Cursor pages are sorted by ascending id")
+		}
+		after, err := decodeCursor(c.QueryParam("cursor"))
+		if err != nil {
+			return ErrBadReqBody
+		}
+		page := PaginateDatacenters(datacenters, after, pageLimit(c))
+		if enrich(c) {
+			ImproveDatacenters(ctx, page.Datacenters)
+		}
+		for i := 0; i < len(page.Datacenters); i++ {
+			page.Datacenters[i].Redact(au)
+		}
+		if body, err = json.Marshal(page); err != nil {
+			return err
+		}
+		return c.JSONBlob(http.StatusOK, body)
+	}
+
+	SortDatacenters(datacenters, field, desc)
+	if enrich(c) {
+		ImproveDatacenters(ctx, datacenters)
+	}
+	for i := 0; i < len(datacenters); i++ {
+		datacenters[i].Redact(au)
+	}
+
+	if fields := requestedFields(c); len(fields) > 0 {
+		selected, err := SelectDatacenterFields(datacenters, fields)
+		if err != nil {
+			return err
+		}
+		if body, err = json.Marshal(selected); err != nil {
+			return err
+		}
+		return c.JSONBlob(http.StatusOK, body)
+	}
+
+	if body, err = json.Marshal(datacenters); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// datacenterExportColumns : columns of the datacenters csv export, secrets
+// are never exported
+var datacenterExportColumns = []string{"id", "name", "type", "group_id", "region", "external_id", "enabled", "credential_status", "created_at"}
+
+// getDatacentersExportHandler : responds to GET /datacenters/export with the
+// redacted list of datacenters as ?format=csv, the default, or json. The
+// list filters are honored
+func getDatacentersExportHandler(c echo.Context) (err error) {
+	au := authenticatedUser(c)
+	datacenters, err := listDatacenters(c, au)
+	if err != nil {
+		return err
+	}
+
+	field, desc, err := sortOrder(c)
+	if err != nil {
+		return err
+	}
+	SortDatacenters(datacenters, field, desc)
+	for i := range datacenters {
+		datacenters[i].Redact(au)
+	}
+
+	switch c.QueryParam("format") {
+	case "", "csv":
+	case "json":
+		return c.JSON(http.StatusOK, datacenters)
+	default:
+		return echo.NewHTTPError(400, "Invalid format, allowed formats are csv and json")
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err = w.Write(datacenterExportColumns); err != nil {
+		return err
+	}
+	for _, d := range datacenters {
+		row := []string{
+			strconv.Itoa(d.ID),
+			d.Name,
+			d.Type,
+			strconv.Itoa(d.GroupID),
+			d.Region,
+			d.ExternalID,
+			strconv.FormatBool(d.IsEnabled()),
+			d.CredentialStatus,
+			d.CreatedAt.Format(time.RFC3339),
+		}
+		if err = w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="datacenters.csv"`)
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// listDatacenters : returns the datacenters the user has access to matching
+// the list filters given on the query params
+func listDatacenters(c echo.Context, au User) (datacenters []Datacenter, err error) {
+	ctx := c.Request().Context()
+	var datacenter Datacenter
+
+	after, before, err := createdRange(c)
+	if err != nil {
+		return nil, err
+	}
+
 	if au.Admin == true {
-		err = datacenter.FindAll(au, &datacenters)
+		err = datacenter.FindAll(ctx, au, &datacenters)
 	} else {
-		datacenters, err = au.Datacenters()
+		datacenters, err = au.Datacenters(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	datacenters = CreatedBetween(datacenters, after, before)
+	if c.QueryParam("include_deleted") != "true" {
+		datacenters = NotDeleted(datacenters)
+	}
+
+	if t := c.QueryParam("type"); t != "" {
+		filtered := []Datacenter{}
+		for _, d := range datacenters {
+			if strings.EqualFold(d.Type, t) {
+				filtered = append(filtered, d)
+			}
+		}
+		datacenters = filtered
+	}
+
+	if q := strings.ToLower(c.QueryParam("q")); q != "" {
+		filtered := []Datacenter{}
+		for _, d := range datacenters {
+			if strings.Contains(strings.ToLower(d.Name), q) {
+				filtered = append(filtered, d)
+			}
+		}
+		datacenters = filtered
+	}
+
+	return datacenters, nil
+}
+
+// sortOrder : returns the field and direction requested with the ?sort= and
+// ?order= query params, by ascending id unless given
+func sortOrder(c echo.Context) (field string, desc bool, err error) {
+	field = c.QueryParam("sort")
+	if field == "" {
+		field = "id"
+	}
+
+	valid := false
+	for _, f := range datacenterSortFields {
+		valid = valid || f == field
+	}
+	if !valid {
+		return "", false, echo.NewHTTPError(400, "Invalid sort field, allowed fields are id, name and type")
+	}
+
+	switch c.QueryParam("order") {
+	case "", "asc":
+		return field, false, nil
+	case "desc":
+		return field, true, nil
+	}
+
+	return "", false, echo.NewHTTPError(400, "Invalid order, allowed orders are asc and desc")
+}
+
+// enrich : checks if the derived datacenter fields, which take extra
+// backend calls, should be added as they are unless ?enrich=false is given
+func enrich(c echo.Context) bool {
+	return c.QueryParam("enrich") != "false"
+}
+
+// requestedFields : returns the json field names requested with the
+// comma separated ?fields= query param, if any
+func requestedFields(c echo.Context) []string {
+	fields := []string{}
+	for _, f := range strings.Split(c.QueryParam("fields"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// createdRange : returns the creation date range requested with the
+// ?created_after= and ?created_before= RFC3339 query params
+func createdRange(c echo.Context) (after time.Time, before time.Time, err error) {
+	if v := c.QueryParam("created_after"); v != "" {
+		if after, err = time.Parse(time.RFC3339, v); err != nil {
+			return after, before, echo.NewHTTPError(400, "Invalid created_after date")
+		}
+	}
+	if v := c.QueryParam("created_before"); v != "" {
+		if before, err = time.Parse(time.RFC3339, v); err != nil {
+			return after, before, echo.NewHTTPError(400, "Invalid created_before date")
+		}
 	}
+	if !after.IsZero() && !before.IsZero() && after.After(before) {
+		return after, before, echo.NewHTTPError(400, "created_after must not be later than created_before")
+	}
+
+	return after, before, nil
+}
+
+// getDatacenterTypesInUseHandler : responds to GET /datacenters/types/in-use/
+// with the distinct types of the user datacenters and their counts
+func getDatacenterTypesInUseHandler(c echo.Context) (err error) {
+	var datacenters []Datacenter
+	var body []byte
+
+	au := authenticatedUser(c)
+	if datacenters, err = listDatacenters(c, au); err != nil {
+		return err
+	}
+
+	if body, err = json.Marshal(TypesInUse(datacenters)); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
 
+// getDatacentersCountHandler : responds to GET /datacenters/count with the
+// number of datacenters the user has access to
+func getDatacentersCountHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	var datacenter Datacenter
+
+	count, err := datacenter.Count(ctx, authenticatedUser(c))
 	if err != nil {
 		return err
 	}
 
-	for i := 0; i < len(datacenters); i++ {
-		datacenters[i].Redact()
-		datacenters[i].Improve()
+	return c.JSON(http.StatusOK, map[string]int{"count": count})
+}
+
+// getDatacentersStatusSummaryHandler : responds to GET /datacenters/status-summary/
+// with the number of user datacenters on each cached credential status
+func getDatacentersStatusSummaryHandler(c echo.Context) (err error) {
+	var datacenters []Datacenter
+
+	au := authenticatedUser(c)
+	if datacenters, err = listDatacenters(c, au); err != nil {
+		return err
+	}
+
+	summary := map[string]int{
+		CredentialsOK:      0,
+		CredentialsError:   0,
+		CredentialsUnknown: 0,
+	}
+	for _, d := range datacenters {
+		summary[credentialStatuses.Get(d.ID)]++
+	}
+
+	return c.JSON(http.StatusOK, summary)
+}
+
+// getEligibleDatacentersHandler : responds to GET /datacenters/eligible/ with
+// the enabled user datacenters supporting the given ?service_type=
+func getEligibleDatacentersHandler(c echo.Context) (err error) {
+	var datacenters []Datacenter
+	var body []byte
+
+	serviceType := c.QueryParam("service_type")
+	if serviceType == "" {
+		return ErrBadReqBody
+	}
+
+	au := authenticatedUser(c)
+	if datacenters, err = listDatacenters(c, au); err != nil {
+		return err
+	}
+
+	eligible := []Datacenter{}
+	for _, d := range datacenters {
+		if d.Deleted || !d.IsEnabled() || !d.Supports(serviceType) {
+			continue
+		}
+		d.Redact(au)
+		eligible = append(eligible, d)
+	}
+
+	if body, err = json.Marshal(eligible); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// getExpiringDatacentersHandler : responds to GET /datacenters/expiring/
+// with the datacenters whose credentials expire ?within= the given duration
+func getExpiringDatacentersHandler(c echo.Context) (err error) {
+	var datacenters []Datacenter
+
+	within, err := time.ParseDuration(c.QueryParam("within"))
+	if err != nil || within < 0 {
+		return ErrBadReqBody
+	}
+
+	au := authenticatedUser(c)
+	if datacenters, err = listDatacenters(c, au); err != nil {
+		return err
+	}
+
+	expiring := ExpiringBefore(datacenters, time.Now().Add(within))
+	for i := 0; i < len(expiring); i++ {
+		expiring[i].Redact(au)
+	}
+
+	return c.JSON(http.StatusOK, expiring)
+}
+
+// getRecentDatacentersHandler : responds to GET /datacenters/recent/ with
+// the datacenters recently viewed by the user, most recent first
+func getRecentDatacentersHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var body []byte
+
+	au := authenticatedUser(c)
+
+	datacenters := []Datacenter{}
+	for _, id := range recentDatacenters.Get(au.Username) {
+		var d Datacenter
+		if err := d.FindByID(ctx, id); err != nil {
+			continue
+		}
+		if au.Admin != true && !au.InGroup(d.GroupID) {
+			continue
+		}
+		d.Redact(au)
+		datacenters = append(datacenters, d)
 	}
 
 	if body, err = json.Marshal(datacenters); err != nil {
@@ -45,53 +398,227 @@ func getDatacentersHandler(c echo.Context) (err error) {
 // getDatacenterHandler : responds to GET /datacenter/:id:/ with the specified
 // datacenter details
 func getDatacenterHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var d Datacenter
 	var body []byte
 
 	id, _ := strconv.Atoi(c.Param("datacenter"))
-	if err := d.FindByID(id); err != nil {
+	if err := d.FindByID(ctx, id); err != nil {
 		return err
 	}
+	if enrich(c) {
+		d.ProviderVersion = d.FetchProviderVersion(ctx)
+		d.CheckHealth(ctx)
+	}
+
+	au := authenticatedUser(c)
+	if au.Admin == true || au.InGroup(d.GroupID) {
+		recentDatacenters.Add(au.Username, d.ID)
+	}
+	d.Redact(au)
 
 	if body, err = json.Marshal(d); err != nil {
 		return err
 	}
 
+	tag := etag(body)
+	c.Response().Header().Set("ETag", tag)
+	if notModified(c, tag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
 	return c.JSONBlob(http.StatusOK, body)
 }
 
-// createDatacenterHandler : responds to POST /datacenters/ by creating a
-// datacenter on the data store
-func createDatacenterHandler(c echo.Context) (err error) {
+// getDatacenterHistoryHandler : responds to GET /datacenters/:id:/history/
+// with a page of the change events of the datacenter, oldest first
+func getDatacenterHistoryHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var d Datacenter
-	var existing Datacenter
-	var body []byte
+	var event AuditEvent
+	var events []AuditEvent
 
 	au := authenticatedUser(c)
 
-	if au.GroupID == 0 {
-		return c.JSONBlob(401, []byte("Current user does not belong to any group.\nPlease assign the user to a group before performing this action"))
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrNotFound
 	}
 
-	if d.Map(c) != nil {
+	after, err := decodeCursor(c.QueryParam("cursor"))
+	if err != nil {
 		return ErrBadReqBody
 	}
 
-	err = d.Validate()
+	if err = event.FindByEntity(ctx, "datacenter", d.ID, &events); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, PaginateAuditEvents(events, after, pageLimit(c)))
+}
+
+// getDatacenterImpactHandler : responds to GET /datacenters/:id:/impact/
+// with the services and transitive dependencies a deletion would affect
+func getDatacenterImpactHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+
+	au := authenticatedUser(c)
+
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrNotFound
+	}
+
+	impact, err := d.Impact(ctx)
 	if err != nil {
+		return echo.NewHTTPError(500, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, impact)
+}
+
+// getDatacenterServicesHandler : responds to GET /datacenters/:id:/services
+// with the services referring to the datacenter
+func getDatacenterServicesHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+
+	au := authenticatedUser(c)
+
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrNotFound
+	}
+
+	ss, err := d.Services(ctx)
+	if err != nil {
+		return echo.NewHTTPError(500, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ss)
+}
+
+// getDatacenterRenameCheckHandler : responds to GET /datacenters/:id:/rename-check
+// reporting if renaming the datacenter to ?name= is safe and the services
+// which would be affected, without renaming it
+func getDatacenterRenameCheckHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+	var existing Datacenter
+
+	au := authenticatedUser(c)
+
+	name := c.QueryParam("name")
+	if name == "" {
 		return ErrBadReqBody
 	}
 
-	d.GroupID = au.GroupID
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrNotFound
+	}
+
+	var named []Datacenter
+	if err := existing.FindByNameAndGroupID(ctx, name, d.GroupID, &named); err == nil {
+		for _, e := range named {
+			if e.ID != d.ID {
+				return echo.NewHTTPError(409, "Specified datacenter already exists")
+			}
+		}
+	}
 
-	if err := existing.FindByName(d.Name, &existing); err == nil {
-		return echo.NewHTTPError(409, "Specified datacenter already exists")
+	services, err := d.Services(ctx)
+	if err != nil {
+		return echo.NewHTTPError(500, err.Error())
 	}
 
-	if err = d.Save(); err != nil {
-		log.Println(err)
+	check := DatacenterRenameCheck{Name: name, AffectedServices: []string{}}
+	for _, s := range services {
+		check.AffectedServices = append(check.AffectedServices, s.Name)
 	}
+	check.Safe = len(check.AffectedServices) == 0
 
+	return c.JSON(http.StatusOK, check)
+}
+
+// getDatacenterByExternalIDHandler : responds to GET /datacenters/by-external/:ext
+// with the datacenter matching the given external reference id
+func getDatacenterByExternalIDHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+	var body []byte
+
+	au := authenticatedUser(c)
+	if err := d.FindByExternalID(ctx, c.Param("ext"), au); err != nil {
+		return err
+	}
+	d.Redact(au)
+	d.CheckHealth(ctx)
+
+	if body, err = json.Marshal(d); err != nil {
+		return err
+	}
+
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// createDatacenterHandler : responds to POST /datacenters/ by creating a
+// datacenter on the data store, a unique name is generated when none is
+// provided and ?autoname=true is set. With ?dry_run=true the datacenter is
+// only checked and returned as it would be created
+func createDatacenterHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+	var body []byte
+
+	au := authenticatedUser(c)
+
+	if he := d.Map(c); he != nil {
+		return he
+	}
+
+	if d.Name == "" && d.Type != "" && c.QueryParam("autoname") == "true" {
+		if err := d.GenerateName(ctx); err == errNoUniqueName {
+			return echo.NewHTTPError(409, err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+
+	dryRun := c.QueryParam("dry_run") == "true"
+	create := createDatacenter
+	if dryRun {
+		create = checkNewDatacenter
+	}
+
+	if conflictingID, err := create(ctx, au, &d); err != nil {
+		if conflictingID != 0 {
+			return renderConflict(c, conflictingID)
+		}
+		return err
+	}
+	if !dryRun {
+		auditDatacenter(c, "create", d, nil)
+	}
+
+	d.Redact(au)
 	if body, err = json.Marshal(d); err != nil {
 		return err
 	}
@@ -99,72 +626,688 @@ func createDatacenterHandler(c echo.Context) (err error) {
 	return c.JSONBlob(http.StatusOK, body)
 }
 
+const (
+	// DuplicateCredentialsWarn : logs datacenters created with the aws
+	// access key id of another datacenter of the group
+	DuplicateCredentialsWarn = "warn"
+	// DuplicateCredentialsBlock : rejects them with a 409
+	DuplicateCredentialsBlock = "block"
+)
+
+// duplicateCredentialsMode : returns how datacenters sharing an aws access
+// key id are handled, as configured on DUPLICATE_CREDENTIALS, empty to allow
+// them
+func duplicateCredentialsMode() string {
+	switch mode := os.Getenv("DUPLICATE_CREDENTIALS"); mode {
+	case DuplicateCredentialsWarn, DuplicateCredentialsBlock:
+		return mode
+	}
+	return ""
+}
+
+// createDatacenter : validates and stores the given datacenter on the user
+// group, returning the id of the datacenter with the same external id if any
+func createDatacenter(ctx context.Context, au User, d *Datacenter) (conflictingID int, err error) {
+	if conflictingID, err = checkNewDatacenter(ctx, au, d); err != nil {
+		return conflictingID, err
+	}
+
+	if err = d.Save(ctx); err != nil {
+		return 0, err
+	}
+	refreshCredentialStatus(*d)
+
+	return 0, nil
+}
+
+// checkNewDatacenter : validates the given datacenter can be created on the
+// user group without storing it, returning the id of the datacenter with
+// the same external id if any
+func checkNewDatacenter(ctx context.Context, au User, d *Datacenter) (conflictingID int, err error) {
+	var existing Datacenter
+
+	if err = d.Validate(); err != nil {
+		return 0, err
+	}
+
+	if au.Admin != true {
+		g := au.Group(ctx)
+		if !g.IsEntitledTo(d.Type) {
+			return 0, echo.NewHTTPError(403, "Current group is not entitled to create "+d.Type+" datacenters")
+		}
+	}
+
+	d.GroupID = au.GroupID
+
+	var named []Datacenter
+	if err = existing.FindByNameAndGroupID(ctx, d.Name, d.GroupID, &named); err != nil {
+		return 0, err
+	}
+	if len(named) > 0 {
+		return 0, echo.NewHTTPError(409, "Specified datacenter already exists")
+	}
+
+	if mode := duplicateCredentialsMode(); mode != "" && d.AccessKeyID != "" {
+		var duplicates []Datacenter
+		if err = existing.FindByCredentialFingerprint(ctx, credentialFingerprint(d.AccessKeyID), d.GroupID, &duplicates); err != nil {
+			return 0, err
+		}
+		if len(duplicates) > 0 {
+			if mode == DuplicateCredentialsBlock {
+				return 0, echo.NewHTTPError(409, "Specified aws access key id is already used by datacenter "+duplicates[0].Name)
+			}
+			log.Println("WARNING: aws access key id of datacenter " + d.Name + " is already used by datacenter " + duplicates[0].Name)
+		}
+	}
+
+	if d.ExternalID != "" {
+		var datacenters []Datacenter
+		if err = existing.FindByExternalIDAndGroupID(ctx, d.ExternalID, d.GroupID, &datacenters); err != nil {
+			return 0, err
+		}
+		if len(datacenters) > 0 {
+			return datacenters[0].ID, echo.NewHTTPError(409, "Specified datacenter external id already exists")
+		}
+	}
+
+	return 0, nil
+}
+
+// renderConflict : responds with a 409 naming the datacenter with the same
+// external id
+func renderConflict(c echo.Context, conflictingID int) error {
+	return renderErrorDetails(c, 409, "Specified datacenter external id already exists", DatacenterConflict{ConflictingID: conflictingID})
+}
+
+// checkChangedDatacenter : checks the name and the external id of a
+// modified datacenter are still unique on its group, when they changed.
+// The id of the datacenter with the same external id is returned
+func checkChangedDatacenter(ctx context.Context, existing Datacenter, d Datacenter) (conflictingID int, err error) {
+	var found Datacenter
+
+	if d.Name != existing.Name {
+		var named []Datacenter
+		if err = found.FindByNameAndGroupID(ctx, d.Name, d.GroupID, &named); err != nil {
+			return 0, err
+		}
+		if len(named) > 0 {
+			return 0, echo.NewHTTPError(409, "Specified datacenter already exists")
+		}
+	}
+
+	if d.ExternalID != "" && d.ExternalID != existing.ExternalID {
+		var datacenters []Datacenter
+		if err = found.FindByExternalIDAndGroupID(ctx, d.ExternalID, d.GroupID, &datacenters); err != nil {
+			return 0, err
+		}
+		if len(datacenters) > 0 {
+			return datacenters[0].ID, echo.NewHTTPError(409, "Specified datacenter external id already exists")
+		}
+	}
+
+	return 0, nil
+}
+
+// bulkCreateDatacentersHandler : responds to POST /datacenters/bulk by
+// creating each of the given datacenters, reporting the outcome of each
+// one without aborting the batch on failures
+func bulkCreateDatacentersHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var items []json.RawMessage
+
+	au := authenticatedUser(c)
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return ErrBadReqBody
+	}
+	if err = json.Unmarshal(data, &items); err != nil || len(items) == 0 {
+		return ErrBadReqBody
+	}
+
+	locale := requestLocale(c.Request().Header.Get("Accept-Language"))
+	results := []DatacenterCreateResult{}
+	errs := []string{}
+	for i, item := range items {
+		var d Datacenter
+		r := DatacenterCreateResult{Index: i}
+
+		if he := d.MapData(ctx, au, item); he != nil {
+			err = he
+		} else {
+			r.ConflictingID, err = createDatacenter(ctx, au, &d)
+		}
+		r.Name = d.Name
+
+		if err != nil {
+			switch e := err.(type) {
+			case ValidationError:
+				r.Error = translate(e.Code, locale)
+			case *echo.HTTPError:
+				r.Error = fmt.Sprint(e.Message)
+				if r.Error == "" {
+					r.Error = http.StatusText(e.Code)
+				}
+			default:
+				r.Error = err.Error()
+			}
+		} else {
+			r.ID = d.ID
+			auditDatacenter(c, "create", d, nil)
+		}
+		results = append(results, r)
+		errs = append(errs, r.Error)
+	}
+
+	return bulkResponse(c, results, errs)
+}
+
+// validateDatacentersImportHandler : responds to POST /datacenters/import/validate/
+// with a per entry report of the given datacenters without creating them
+func validateDatacentersImportHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var datacenters []Datacenter
+	var body []byte
+
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return ErrBadReqBody
+	}
+	if err = json.Unmarshal(data, &datacenters); err != nil {
+		return ErrBadReqBody
+	}
+
+	au := authenticatedUser(c)
+	locale := requestLocale(c.Request().Header.Get("Accept-Language"))
+	names := make(map[string]bool)
+	reports := []DatacenterImportReport{}
+
+	for i, d := range datacenters {
+		r := DatacenterImportReport{Index: i, Name: d.Name}
+
+		if err := d.Validate(); err != nil {
+			if ve, ok := err.(ValidationError); ok {
+				r.Errors = append(r.Errors, translate(ve.Code, locale))
+			} else {
+				r.Errors = append(r.Errors, err.Error())
+			}
+		}
+
+		if d.Name != "" {
+			var existing Datacenter
+			var named []Datacenter
+			if names[d.Name] {
+				r.Errors = append(r.Errors, "Datacenter name is repeated on the import")
+			} else if err = existing.FindByNameAndGroupID(ctx, d.Name, au.GroupID, &named); err != nil {
+				return err
+			} else if len(named) > 0 {
+				r.Errors = append(r.Errors, "Specified datacenter already exists")
+			}
+			names[d.Name] = true
+		}
+
+		if d.ExternalID != "" {
+			var existing Datacenter
+			var conflicts []Datacenter
+			if err = existing.FindByExternalIDAndGroupID(ctx, d.ExternalID, au.GroupID, &conflicts); err != nil {
+				return err
+			}
+			if len(conflicts) > 0 {
+				r.Errors = append(r.Errors, "Specified datacenter external id already exists")
+				r.ConflictingID = conflicts[0].ID
+			}
+		}
+
+		r.Valid = len(r.Errors) == 0
+		reports = append(reports, r)
+	}
+
+	if body, err = json.Marshal(reports); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
 // updateDatacenterHandler : responds to PUT /datacenters/:id: by updating
-// an existing datacenter
+// an existing datacenter, listing the fields which actually changed
 func updateDatacenterHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var d Datacenter
 	var existing Datacenter
 	var body []byte
 
-	if d.Map(c) != nil {
-		return ErrBadReqBody
+	if he := d.Map(c); he != nil {
+		return he
 	}
 
 	au := authenticatedUser(c)
 
 	id, err := strconv.Atoi(c.Param("datacenter"))
-	if err = existing.FindByID(id); err != nil {
+	if err = existing.FindByID(ctx, id); err != nil {
 		return err
 	}
 
-	if au.GroupID != au.GroupID {
+	if au.Admin != true && !au.InGroup(existing.GroupID) {
 		return ErrUnauthorized
 	}
 
+	stored := existing
+
 	existing.Username = d.Username
 	existing.Password = d.Password
 	existing.AccessKeyID = d.AccessKeyID
 	existing.SecretAccessKey = d.SecretAccessKey
+	existing.SubscriptionID = d.SubscriptionID
+	existing.ClientID = d.ClientID
+	existing.ClientSecret = d.ClientSecret
+	existing.TenantID = d.TenantID
+	existing.ServiceAccountJSON = d.ServiceAccountJSON
+	if d.Tags != nil {
+		existing.Tags = d.Tags
+	}
 
-	if err = existing.Save(); err != nil {
-		log.Println(err)
+	if err = existing.ValidateUpdate(stored); err != nil {
+		return err
 	}
+	changed := stored.ChangedFields(existing)
 
-	if body, err = json.Marshal(d); err != nil {
+	if err = existing.Save(ctx); err != nil {
+		return err
+	}
+	refreshCredentialStatus(existing)
+	auditDatacenter(c, "update", existing, changed)
+	existing.Redact(au)
+
+	res := struct {
+		Datacenter
+		Changed []string `json:"changed"`
+	}{existing, changed}
+
+	if body, err = json.Marshal(res); err != nil {
 		return ErrInternal
 	}
 
 	return c.JSONBlob(http.StatusOK, body)
 }
 
+// encryptDatacentersHandler : responds to POST /datacenters/encrypt/ by
+// encrypting the credentials of all datacenters still stored as plaintext
+func encryptDatacentersHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var datacenters []Datacenter
+	var datacenter Datacenter
+	var body []byte
+
+	au := authenticatedUser(c)
+	if au.Admin != true {
+		return ErrUnauthorized
+	}
+	if !encryptionEnabled() {
+		return ErrServiceUnavailable
+	}
+
+	if err = datacenter.FindBy(ctx, make(map[string]interface{}), &datacenters); err != nil {
+		return err
+	}
+
+	migrated := []Datacenter{}
+	for _, d := range datacenters {
+		if d.CredentialsEncrypted {
+			continue
+		}
+		encrypted := d.FilledCredentials()
+		if err = d.Encrypt(); err != nil {
+			return ErrInternal
+		}
+		if err = d.Save(ctx); err != nil {
+			return err
+		}
+		auditDatacenter(c, "update", d, encrypted)
+		d.Redact(au)
+		migrated = append(migrated, d)
+	}
+
+	if body, err = json.Marshal(migrated); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// validateAllDatacentersHandler : responds to POST /datacenters/validate-all/
+// by verifying the credentials of all datacenters, at most
+// VALIDATE_CONCURRENCY at once, returning the partial results when the sweep
+// takes longer than VALIDATE_DEADLINE seconds
+func validateAllDatacentersHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var datacenters []Datacenter
+	var datacenter Datacenter
+
+	au := authenticatedUser(c)
+	if au.Admin != true {
+		return ErrUnauthorized
+	}
+
+	if err = datacenter.FindAll(ctx, au, &datacenters); err != nil {
+		return err
+	}
+
+	concurrency := envPositiveInt("VALIDATE_CONCURRENCY", DefaultValidateConcurrency)
+	timeout := time.Duration(envPositiveInt("VALIDATE_TIMEOUT", DefaultVerifyTimeout)) * time.Second
+	deadline := time.Duration(envPositiveInt("VALIDATE_DEADLINE", DefaultValidateDeadline)) * time.Second
+
+	sweep := validateAll(datacenters, concurrency, deadline, func(d Datacenter) DatacenterValidation {
+		return validateCredentials(ctx, d, timeout)
+	})
+
+	return c.JSON(http.StatusOK, sweep)
+}
+
+// testDatacenterHandler : responds to POST /datacenters/:id:/test by asking
+// the provider connectors whether the stored datacenter credentials work
+func testDatacenterHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+
+	au := authenticatedUser(c)
+
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrUnauthorized
+	}
+
+	timeout := time.Duration(envPositiveInt("VALIDATE_TIMEOUT", DefaultVerifyTimeout)) * time.Second
+	v, err := verifyCredentials(ctx, d, timeout)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, v)
+}
+
+// patchDatacenterHandler : responds to PATCH /datacenters/:id: by
+// overwriting only the fields present on the request body, so credentials
+// can be rotated one at a time
+func patchDatacenterHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+	var body []byte
+
+	au := authenticatedUser(c)
+
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return ErrBadReqBody
+	}
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(data, &fields); err != nil || len(fields) == 0 {
+		return ErrBadReqBody
+	}
+	for _, f := range immutableFields {
+		if _, ok := fields[f]; ok {
+			return echo.NewHTTPError(400, "Datacenter "+f+" can't be patched")
+		}
+	}
+	if he := checkWritable(au, fields); he != nil {
+		return he
+	}
+
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrUnauthorized
+	}
+
+	existing := d
+	if err = d.Patch(fields); err != nil {
+		return ErrBadReqBody
+	}
+	if err = d.ValidateUpdate(existing); err != nil {
+		return err
+	}
+	if conflictingID, err := checkChangedDatacenter(ctx, existing, d); err != nil {
+		if conflictingID != 0 {
+			return renderConflict(c, conflictingID)
+		}
+		return err
+	}
+
+	if err = d.Save(ctx); err != nil {
+		return err
+	}
+	if len(existing.ChangedCredentials(d)) > 0 {
+		refreshCredentialStatus(d)
+	}
+	auditDatacenter(c, "update", d, patchedFields(fields))
+	d.Redact(au)
+
+	if body, err = json.Marshal(d); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// rotateDatacenterCredentialsHandler : responds to PUT
+// /datacenters/:id:/credentials by replacing only the datacenter
+// credentials, any other field on the body is ignored
+func rotateDatacenterCredentialsHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var d Datacenter
+	var cr DatacenterCredentials
+	var body []byte
+
+	au := authenticatedUser(c)
+
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return ErrBadReqBody
+	}
+	if err = json.Unmarshal(data, &cr); err != nil {
+		return ErrBadReqBody
+	}
+	if he := checkWritable(au, cr.fields()); he != nil {
+		return he
+	}
+
+	id, _ := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrUnauthorized
+	}
+
+	existing := d
+	d.RotateCredentials(cr)
+	if err = d.ValidateUpdate(existing); err != nil {
+		return err
+	}
+
+	if err = d.Save(ctx); err != nil {
+		return err
+	}
+	changed := existing.ChangedCredentials(d)
+	if len(changed) > 0 {
+		refreshCredentialStatus(d)
+	}
+	auditDatacenter(c, "update", d, changed)
+	d.Redact(au)
+
+	if body, err = json.Marshal(d); err != nil {
+		return err
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// patchedFields : returns the sorted names of the patched fields
+func patchedFields(fields map[string]interface{}) []string {
+	names := []string{}
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bulkEnableDatacentersHandler : responds to POST /datacenters/set-enabled/
+// by enabling or disabling each of the given datacenters
+func bulkEnableDatacentersHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var input struct {
+		IDs     []int `json:"ids"`
+		Enabled *bool `json:"enabled"`
+	}
+
+	au := authenticatedUser(c)
+
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return ErrBadReqBody
+	}
+	if err = json.Unmarshal(data, &input); err != nil || input.Enabled == nil || len(input.IDs) == 0 {
+		return ErrBadReqBody
+	}
+
+	results := []DatacenterEnableResult{}
+	errs := []string{}
+	for _, id := range input.IDs {
+		r := DatacenterEnableResult{ID: id}
+		if d, err := setDatacenterEnabled(ctx, au, id, *input.Enabled); err != nil {
+			r.Error = err.Error()
+			if he, ok := err.(*echo.HTTPError); ok {
+				r.Error = http.StatusText(he.Code)
+			}
+		} else {
+			r.Enabled = d.IsEnabled()
+			auditDatacenter(c, "update", d, []string{"enabled"})
+		}
+		results = append(results, r)
+		errs = append(errs, r.Error)
+	}
+
+	return bulkResponse(c, results, errs)
+}
+
+// setDatacenterEnabled : enables or disables the datacenter if the user is
+// allowed to manage it
+func setDatacenterEnabled(ctx context.Context, au User, id int, enabled bool) (d Datacenter, err error) {
+	if err = d.FindByID(ctx, id); err != nil {
+		return d, err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return d, ErrUnauthorized
+	}
+
+	d.Enabled = &enabled
+	if err = d.Save(ctx); err != nil {
+		return d, err
+	}
+
+	return d, nil
+}
+
 // deleteDatacenterHandler : responds to DELETE /datacenters/:id: by deleting an
-// existing datacenter
+// existing datacenter, or only flagging it as deleted with ?soft=true
 func deleteDatacenterHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var d Datacenter
 
 	au := authenticatedUser(c)
 
 	id, err := strconv.Atoi(c.Param("datacenter"))
-	if err = d.FindByID(id); err != nil {
+	if err = d.FindByID(ctx, id); err != nil {
 		return err
 	}
 
-	if au.GroupID != d.GroupID {
+	if au.Admin != true && !au.InGroup(d.GroupID) {
 		return ErrUnauthorized
 	}
 
-	ss, err := d.Services()
+	ss, err := d.Services(ctx)
 	if err != nil {
 		return echo.NewHTTPError(500, err.Error())
 	}
 
 	if len(ss) > 0 {
-		return echo.NewHTTPError(400, "Existing services are referring to this datacenter.")
+		return renderErrorDetails(c, http.StatusBadRequest, "Existing services are referring to this datacenter.", DatacenterInUse{
+			Reason: "DATACENTER_HAS_SERVICES",
+			Hint:   "Move its services to another datacenter before deleting it",
+			Drain:  drainURL(),
+		})
+	}
+
+	if c.QueryParam("soft") == "true" {
+		now := time.Now()
+		d.Deleted = true
+		d.DeletedAt = &now
+		if err := d.Save(ctx); err != nil {
+			return err
+		}
+		auditDatacenter(c, "delete", d, []string{"deleted", "deleted_at"})
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"deleted": true,
+			"soft":    true,
+			"id":      d.ID,
+		})
+	}
+
+	if err := d.Delete(ctx); err != nil {
+		return err
+	}
+	auditDatacenter(c, "delete", d, nil)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deleted": true,
+		"id":      d.ID,
+	})
+}
+
+// restoreDatacenterHandler : responds to POST /datacenters/:id:/restore by
+// clearing the soft delete of a datacenter
+func restoreDatacenterHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	var d Datacenter
+
+	au := authenticatedUser(c)
+
+	id, err := strconv.Atoi(c.Param("datacenter"))
+	if err = d.FindByIDWithDeleted(ctx, id); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrUnauthorized
+	}
+
+	if !d.Deleted {
+		return ErrNotFound
+	}
+
+	var named []Datacenter
+	if err = d.FindByNameAndGroupID(ctx, d.Name, d.GroupID, &named); err != nil {
+		return err
+	}
+	for _, other := range NotDeleted(named) {
+		if other.ID != d.ID {
+			return echo.NewHTTPError(409, "Specified datacenter already exists")
+		}
 	}
 
-	if err := d.Delete(); err != nil {
+	d.Deleted = false
+	d.DeletedAt = nil
+	if err := d.Save(ctx); err != nil {
 		return err
 	}
+	auditDatacenter(c, "restore", d, []string{"deleted", "deleted_at"})
+	d.Redact(au)
 
-	return c.String(http.StatusOK, "")
+	return c.JSON(http.StatusOK, d)
 }
@@ -0,0 +1,141 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// timingsKey : request context key holding the timings of the request
+// being served while DEBUG is enabled
+type timingsKey struct{}
+
+// timings keeps the milliseconds spent on each NATS subject by a request
+type timings struct {
+	sync.Mutex
+	ms map[string]int64
+}
+
+// Add : adds the elapsed time to the subject
+func (t *timings) Add(subject string, elapsed time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.ms[subject] += int64(elapsed / time.Millisecond)
+}
+
+// Get : returns a copy of the recorded timings
+func (t *timings) Get() map[string]int64 {
+	t.Lock()
+	defer t.Unlock()
+
+	ms := make(map[string]int64, len(t.ms))
+	for k, v := range t.ms {
+		ms[k] = v
+	}
+	return ms
+}
+
+// contextTimings : returns the timings of the request the context belongs
+// to, nil when it isn't served with DEBUG enabled
+func contextTimings(ctx context.Context) *timings {
+	t, _ := ctx.Value(timingsKey{}).(*timings)
+	return t
+}
+
+// request : sends a NATS request recording how long the subject took, on
+// the debug timings of the request the context belongs to and the metrics.
+// It is traced as a child of the request span, if any, carrying its trace
+// context on the message headers. It fails right away while NATS is
+// disconnected
+func request(ctx context.Context, subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	if !natsConnected() {
+		return nil, errNatsDisconnected
+	}
+
+	ctx, span := startNATSSpan(ctx, subject)
+	defer span.End()
+
+	req := nats.NewMsg(subject)
+	req.Data = data
+	if n.HeadersSupported() {
+		traceContext.Inject(ctx, natsHeaderCarrier(req.Header))
+	}
+
+	start := time.Now()
+	msg, err := n.RequestMsg(req, timeout)
+	if t := contextTimings(ctx); t != nil {
+		t.Add(subject, time.Since(start))
+	}
+	metrics.ObserveNATS(subject, time.Since(start))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return msg, err
+}
+
+// debugWriter holds the response body back so it can be extended
+type debugWriter struct {
+	*bytes.Buffer
+	http.ResponseWriter
+}
+
+func (w *debugWriter) Write(b []byte) (int, error) {
+	return w.Buffer.Write(b)
+}
+
+// debugTimings : middleware adding to json object responses a "timings"
+// section with the milliseconds spent on each NATS subject when DEBUG is
+// enabled. Only the subjects requested with the request context are
+// reported, not the ones requested in the background
+func debugTimings(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if os.Getenv("DEBUG") != "true" {
+			return next(c)
+		}
+
+		t := &timings{ms: make(map[string]int64)}
+		c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), timingsKey{}, t)))
+
+		w := c.Response().Writer
+		body := new(bytes.Buffer)
+		c.Response().Writer = &debugWriter{Buffer: body, ResponseWriter: w}
+
+		err := next(c)
+
+		c.Response().Writer = w
+		if body.Len() == 0 {
+			// nothing was written, the error handler will respond
+			return err
+		}
+
+		var o map[string]json.RawMessage
+		if json.Unmarshal(body.Bytes(), &o) == nil && o != nil {
+			if data, merr := json.Marshal(t.Get()); merr == nil {
+				o["timings"] = data
+				if extended, merr := json.Marshal(o); merr == nil {
+					body = bytes.NewBuffer(extended)
+				}
+			}
+		}
+
+		if _, werr := w.Write(body.Bytes()); werr != nil {
+			return werr
+		}
+
+		return err
+	}
+}
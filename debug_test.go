@@ -0,0 +1,174 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func findDatacenterHandler(c echo.Context) error {
+	var d Datacenter
+	if err := d.FindByID(c.Request().Context(), 1); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, d)
+}
+
+// findDatacenterWithBackgroundHandler : finds a datacenter while a group is
+// requested in the background
+func findDatacenterWithBackgroundHandler(c echo.Context) error {
+	done := make(chan struct{})
+	go func() {
+		_, _ = request(context.Background(), "group.get", []byte(`{"id":1}`), time.Second)
+		close(done)
+	}()
+	<-done
+	return findDatacenterHandler(c)
+}
+
+// improveDatacentersHandler : improves a datacenter fetching its provider
+// version on its own goroutine
+func improveDatacentersHandler(c echo.Context) error {
+	datacenters := []Datacenter{{ID: 1, Type: "aws", GroupID: 1}}
+	ImproveDatacenters(c.Request().Context(), datacenters)
+	return c.JSON(http.StatusOK, datacenters[0])
+}
+
+func TestDebugTimings(t *testing.T) {
+	testsSetup()
+	setup()
+	h := handle(debugTimings(findDatacenterHandler))
+
+	Convey("Scenario: reporting the time spent on each subject", t, func() {
+		Convey("Given debug mode is disabled", func() {
+			_ = os.Unsetenv("DEBUG")
+			getDatacenterSubscriber(1)
+			resp, err := doRequest("GET", "/api/datacenters/1", nil, nil, h, nil)
+
+			Convey("Then the response should not include timings", func() {
+				var r map[string]interface{}
+				So(err, ShouldBeNil)
+				So(json.Unmarshal(resp, &r), ShouldBeNil)
+				So(r["timings"], ShouldBeNil)
+				So(r["name"], ShouldEqual, "test")
+			})
+		})
+
+		Convey("Given debug mode is enabled", func() {
+			_ = os.Setenv("DEBUG", "true")
+			getDatacenterSubscriber(1)
+			resp, err := doRequest("GET", "/api/datacenters/1", nil, nil, h, nil)
+
+			Convey("Then the response should include the timings of each subject", func() {
+				var r struct {
+					Name    string           `json:"name"`
+					Timings map[string]int64 `json:"timings"`
+				}
+				So(err, ShouldBeNil)
+				So(json.Unmarshal(resp, &r), ShouldBeNil)
+				So(r.Name, ShouldEqual, "test")
+				So(r.Timings, ShouldContainKey, "datacenter.get")
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("DEBUG")
+			})
+		})
+	})
+
+	Convey("Scenario: reporting timings with debug mode enabled", t, func() {
+		_ = os.Setenv("DEBUG", "true")
+
+		Convey("Given a subject is requested in the background", func() {
+			getDatacenterSubscriber(1)
+			foundSubscriber("group.get", `{"id":1,"name":"test"}`, 1)
+			resp, err := doRequest("GET", "/api/datacenters/1", nil, nil, handle(debugTimings(findDatacenterWithBackgroundHandler)), nil)
+
+			Convey("Then it should not be attributed to the request", func() {
+				var r struct {
+					Timings map[string]int64 `json:"timings"`
+				}
+				So(err, ShouldBeNil)
+				So(json.Unmarshal(resp, &r), ShouldBeNil)
+				So(r.Timings, ShouldContainKey, "datacenter.get")
+				So(r.Timings, ShouldNotContainKey, "group.get")
+			})
+		})
+
+		Convey("Given datacenters are improved concurrently with the request context", func() {
+			foundSubscriber("group.get", `{"id":1,"name":"test"}`, 1)
+			foundSubscriber("datacenter.version", `{"version":"5.5"}`, 1)
+			resp, err := doRequest("GET", "/api/datacenters/1", nil, nil, handle(debugTimings(improveDatacentersHandler)), nil)
+
+			Convey("Then their subjects should be attributed to the request", func() {
+				var r struct {
+					Timings map[string]int64 `json:"timings"`
+				}
+				So(err, ShouldBeNil)
+				So(json.Unmarshal(resp, &r), ShouldBeNil)
+				So(r.Timings, ShouldContainKey, "datacenter.version")
+			})
+		})
+
+		Convey("Given a handler failing without writing a response", func() {
+			e := echo.New()
+			e.Use(debugTimings)
+			e.GET("/missing", func(c echo.Context) error {
+				return ErrNotFound
+			})
+
+			Convey("When it is requested", func() {
+				req, _ := http.NewRequest("GET", "/missing", nil)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+
+				Convey("Then the error status should be kept", func() {
+					So(rec.Code, ShouldEqual, 404)
+				})
+			})
+		})
+
+		Convey("Given slow requests served at once", func() {
+			e := echo.New()
+			e.Use(debugTimings)
+			e.GET("/slow", func(c echo.Context) error {
+				time.Sleep(100 * time.Millisecond)
+				return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+			})
+
+			Convey("When they are requested concurrently", func() {
+				start := time.Now()
+				done := make(chan int, 2)
+				for i := 0; i < 2; i++ {
+					go func() {
+						req, _ := http.NewRequest("GET", "/slow", nil)
+						rec := httptest.NewRecorder()
+						e.ServeHTTP(rec, req)
+						done <- rec.Code
+					}()
+				}
+				codes := []int{<-done, <-done}
+
+				Convey("Then they should not be served one at a time", func() {
+					So(codes, ShouldResemble, []int{200, 200})
+					So(time.Since(start), ShouldBeLessThan, 190*time.Millisecond)
+				})
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("DEBUG")
+		})
+	})
+}
@@ -0,0 +1,60 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// deprecatedRoutes : returns the path prefixes configured on
+// DEPRECATED_ROUTES with their sunset date if any, as
+// "/api/loggers=2027-01-01T00:00:00Z,/api/session"
+func deprecatedRoutes() map[string]string {
+	routes := make(map[string]string)
+	for _, r := range strings.Split(os.Getenv("DEPRECATED_ROUTES"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(r), "=", 2)
+		if parts[0] == "" {
+			continue
+		}
+		routes[parts[0]] = ""
+		if len(parts) == 2 {
+			routes[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	return routes
+}
+
+// deprecation : middleware flagging the routes matching any of the
+// DEPRECATED_ROUTES prefixes with a Deprecation header, and a Sunset one
+// when the date they will be removed is configured
+func deprecation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+		for prefix, sunset := range deprecatedRoutes() {
+			if !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			c.Response().Header().Set("Deprecation", "true")
+			if sunset == "" {
+				break
+			}
+			t, err := time.Parse(time.RFC3339, sunset)
+			if err != nil {
+				log.Println("Invalid sunset date on DEPRECATED_ROUTES for " + prefix)
+				break
+			}
+			c.Response().Header().Set("Sunset", t.UTC().Format(http.TimeFormat))
+			break
+		}
+
+		return next(c)
+	}
+}
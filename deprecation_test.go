@@ -0,0 +1,45 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeprecation(t *testing.T) {
+	h := handle(deprecation(okHandler))
+
+	Convey("Scenario: flagging deprecated routes", t, func() {
+		_ = os.Setenv("DEPRECATED_ROUTES", "/api/loggers=2027-01-01T00:00:00Z")
+
+		Convey("Given a request to a deprecated route", func() {
+			rec, err := doRequestRecorder("GET", "/api/loggers/", nil, nil, h, nil, nil)
+
+			Convey("Then it should still be served with the deprecation headers", func() {
+				So(err, ShouldBeNil)
+				So(rec.Body.String(), ShouldEqual, "ok")
+				So(rec.Header().Get("Deprecation"), ShouldEqual, "true")
+				So(rec.Header().Get("Sunset"), ShouldEqual, "Fri, 01 Jan 2027 00:00:00 GMT")
+			})
+		})
+
+		Convey("Given a request to any other route", func() {
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+			Convey("Then it should not be flagged", func() {
+				So(err, ShouldBeNil)
+				So(rec.Header().Get("Deprecation"), ShouldEqual, "")
+				So(rec.Header().Get("Sunset"), ShouldEqual, "")
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("DEPRECATED_ROUTES")
+		})
+	})
+}
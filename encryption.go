@@ -0,0 +1,125 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// encryptedPrefix : marks the credentials encrypted by the gateway and the
+// version of the scheme used, AES-256-GCM with a random nonce
+const encryptedPrefix = "enc:v1:"
+
+// errNoEncryptionKey : returned when encrypting without ENCRYPTION_KEY
+var errNoEncryptionKey = errors.New("ENCRYPTION_KEY is not set")
+
+// encryptionEnabled : checks if ENCRYPTION_KEY is set
+func encryptionEnabled() bool {
+	return os.Getenv("ENCRYPTION_KEY") != ""
+}
+
+// credentialsCipher : returns the AES-GCM cipher keyed with the sha256 of
+// ENCRYPTION_KEY
+func credentialsCipher() (cipher.AEAD, error) {
+	if !encryptionEnabled() {
+		return nil, errNoEncryptionKey
+	}
+	key := sha256.Sum256([]byte(os.Getenv("ENCRYPTION_KEY")))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isEncryptedCredential : checks if the credential is stored encrypted
+func isEncryptedCredential(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// encryptCredential : returns the given plaintext encrypted and prefixed
+// with encryptedPrefix
+func encryptCredential(plaintext string) (string, error) {
+	gcm, err := credentialsCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(encryptedPrefix))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptLegacyCredential : returns the plaintext of a credential encrypted
+// with ERNEST_CRYPTO_KEY before the enc:v1 scheme, as base64url(iv|AES-CFB
+// ciphertext). The scheme isn't authenticated, so values which don't decode
+// to valid text are taken as plaintext
+func decryptLegacyCredential(value string) (string, bool) {
+	key := os.Getenv("ERNEST_CRYPTO_KEY")
+	if key == "" || value == "" {
+		return value, false
+	}
+
+	data, err := base64.URLEncoding.DecodeString(value)
+	if err != nil || len(data) <= aes.BlockSize {
+		return value, false
+	}
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return value, false
+	}
+	plaintext := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCFBDecrypter(block, data[:aes.BlockSize]).XORKeyStream(plaintext, data[aes.BlockSize:])
+	if !utf8.Valid(plaintext) {
+		return value, false
+	}
+	for _, r := range string(plaintext) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return value, false
+		}
+	}
+	return string(plaintext), true
+}
+
+// decryptCredential : returns the plaintext of an encrypted credential and
+// whether it was actually encrypted. Values which can't be authenticated
+// with ENCRYPTION_KEY are logged and returned as they are, so they are
+// never replaced by garbage nor lost on the next save. Values without the
+// encryptedPrefix are decrypted as legacy ones when ERNEST_CRYPTO_KEY is set
+func decryptCredential(value string) (string, bool) {
+	if !isEncryptedCredential(value) {
+		return decryptLegacyCredential(value)
+	}
+
+	gcm, err := credentialsCipher()
+	if err != nil {
+		log.Println("Can't decrypt credential: " + err.Error())
+		return value, true
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(data) < gcm.NonceSize() {
+		log.Println("Can't decrypt credential: invalid ciphertext")
+		return value, true
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(encryptedPrefix))
+	if err != nil {
+		log.Println("Can't decrypt credential: " + err.Error())
+		return value, true
+	}
+	return string(plaintext), true
+}
@@ -6,6 +6,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -36,3 +37,62 @@ func responseErr(msg *nats.Msg) *ResponseError {
 
 	return &e
 }
+
+// ErrorEnvelope holds the body of every error response, along with the
+// details of the errors carrying some
+type ErrorEnvelope struct {
+	Error   string      `json:"error"`
+	Code    int         `json:"code"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// httpError : builds an http error with the given message, unquoting the
+// json strings returned by the stores
+func httpError(code int, msg string) *echo.HTTPError {
+	return echo.NewHTTPError(code, strings.Trim(msg, `"`))
+}
+
+// renderErrorDetails : responds with the given error status and message
+// wrapped on an ErrorEnvelope along with its details
+func renderErrorDetails(c echo.Context, code int, msg string, details interface{}) error {
+	return c.JSON(code, ErrorEnvelope{Error: msg, Code: code, Details: details})
+}
+
+// errorStatus : returns the status httpErrorHandler responds with for the
+// given error
+func errorStatus(err error) int {
+	switch e := err.(type) {
+	case *echo.HTTPError:
+		return e.Code
+	case ValidationError:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// renderError : responds with the error wrapped on an ErrorEnvelope, errors
+// other than http ones are reported as internal errors
+func renderError(err error, c echo.Context) {
+	e := ErrorEnvelope{Error: http.StatusText(http.StatusInternalServerError), Code: http.StatusInternalServerError}
+	if he, ok := err.(*echo.HTTPError); ok {
+		e.Code = he.Code
+		e.Error = http.StatusText(he.Code)
+		if msg := fmt.Sprint(he.Message); msg != "" && msg != "<nil>" {
+			e.Error = msg
+		}
+	} else {
+		c.Logger().Error(err)
+	}
+
+	if c.Response().Committed {
+		return
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(e.Code)
+	} else {
+		err = c.JSON(e.Code, e)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
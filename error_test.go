@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func renderedError(err error) (int, map[string]interface{}) {
+	e := echo.New()
+	req, _ := http.NewRequest("GET", "/datacenters/1", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, echo.NewResponse(rec, e))
+
+	httpErrorHandler(err, c)
+
+	body := make(map[string]interface{})
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	Convey("Scenario: rendering errors", t, func() {
+		Convey("Given a handler returns a not found error", func() {
+			code, body := renderedError(ErrNotFound)
+
+			Convey("Then it should be wrapped on the envelope", func() {
+				So(code, ShouldEqual, 404)
+				So(body, ShouldResemble, map[string]interface{}{"error": "Not Found", "code": float64(404)})
+			})
+		})
+
+		Convey("Given a handler returns a forbidden error", func() {
+			code, body := renderedError(ErrUnauthorized)
+
+			Convey("Then it should be wrapped on the envelope", func() {
+				So(code, ShouldEqual, 403)
+				So(body, ShouldResemble, map[string]interface{}{"error": "Forbidden", "code": float64(403)})
+			})
+		})
+
+		Convey("Given a handler returns a conflict error", func() {
+			code, body := renderedError(echo.NewHTTPError(409, "Specified datacenter already exists"))
+
+			Convey("Then it should be wrapped on the envelope", func() {
+				So(code, ShouldEqual, 409)
+				So(body, ShouldResemble, map[string]interface{}{"error": "Specified datacenter already exists", "code": float64(409)})
+			})
+		})
+
+		Convey("Given a handler returns any other error", func() {
+			code, body := renderedError(errors.New("boom"))
+
+			Convey("Then it should be reported as an internal error", func() {
+				So(code, ShouldEqual, 500)
+				So(body, ShouldResemble, map[string]interface{}{"error": "Internal Server Error", "code": float64(500)})
+			})
+		})
+	})
+}
@@ -5,17 +5,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/labstack/echo"
 )
 
 // Group holds the group response from group-store
 type Group struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Entitlements []string `json:"entitlements,omitempty"`
+	// DefaultRegion is applied to the group aws datacenters created or
+	// updated without a region
+	DefaultRegion string `json:"default_region,omitempty"`
 }
 
 // Validate the group
@@ -49,10 +56,10 @@ func (g *Group) Map(c echo.Context) *echo.HTTPError {
 }
 
 // FindByName : Searches for all groups with a name equal to the specified
-func (g *Group) FindByName(name string, group *Group) (err error) {
+func (g *Group) FindByName(ctx context.Context, name string, group *Group) (err error) {
 	query := make(map[string]interface{})
 	query["name"] = name
-	if err := NewBaseModel("group").GetBy(query, group); err != nil {
+	if err := NewBaseModel("group").GetBy(ctx, query, group); err != nil {
 		return err
 	}
 	return nil
@@ -60,55 +67,77 @@ func (g *Group) FindByName(name string, group *Group) (err error) {
 
 // FindAll : Searches for all groups on the store current user
 // has access to
-func (g *Group) FindAll(au User, groups *[]Group) (err error) {
+func (g *Group) FindAll(ctx context.Context, au User, groups *[]Group) (err error) {
 	query := make(map[string]interface{})
 	if !au.Admin {
 		query["group_id"] = au.GroupID
 	}
-	if err := NewBaseModel("group").FindBy(query, groups); err != nil {
+	if err := NewBaseModel("group").FindBy(ctx, query, groups); err != nil {
 		return err
 	}
 	return nil
 }
 
 // FindByID : Gets a model by its id
-func (g *Group) FindByID(id int) (err error) {
+func (g *Group) FindByID(ctx context.Context, id int) (err error) {
 	query := make(map[string]interface{})
 	query["id"] = id
-	return NewBaseModel("group").GetBy(query, g)
+	return NewBaseModel("group").GetBy(ctx, query, g)
 }
 
 // Save : calls group.set with the marshalled current group
-func (g *Group) Save() (err error) {
-	if err := NewBaseModel("group").Save(g); err != nil {
+func (g *Group) Save(ctx context.Context) (err error) {
+	if err := NewBaseModel("group").Save(ctx, g); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Delete : will delete a group by its id
-func (g *Group) Delete() (err error) {
+func (g *Group) Delete(ctx context.Context) (err error) {
 	query := make(map[string]interface{})
 	query["id"] = g.ID
-	if err := NewBaseModel("group").Delete(query); err != nil {
+	if err := NewBaseModel("group").Delete(ctx, query); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Users : Get the users related with current group
-func (g *Group) Users() (users []User, err error) {
+func (g *Group) Users(ctx context.Context) (users []User, err error) {
 	var u User
 	u.GroupID = g.ID
-	err = u.FindAll(&users)
+	err = u.FindAll(ctx, &users)
 
 	return users, err
 }
 
 // Datacenters : Get the datacenters related with current group
-func (g *Group) Datacenters() (datacenters []Datacenter, err error) {
+func (g *Group) Datacenters(ctx context.Context) (datacenters []Datacenter, err error) {
 	var d Datacenter
-	err = d.FindByGroupID(g.ID, &datacenters)
+	err = d.FindByGroupID(ctx, g.ID, &datacenters)
 
 	return datacenters, err
 }
+
+// IsEntitledTo : checks if the group can use datacenters of the given type,
+// only types listed on RESTRICTED_DATACENTER_TYPES require an entitlement
+func (g *Group) IsEntitledTo(datacenterType string) bool {
+	isRestricted := false
+	for _, t := range strings.Split(os.Getenv("RESTRICTED_DATACENTER_TYPES"), ",") {
+		if strings.TrimSpace(t) == datacenterType {
+			isRestricted = true
+		}
+	}
+	if !isRestricted {
+		return true
+	}
+
+	for _, e := range g.Entitlements {
+		if e == datacenterType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -7,7 +7,6 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -15,22 +14,21 @@ import (
 )
 
 // getGroupsHandler : responds to GET /groups/ with a list of all
-// groups
+// groups, only admins can list them
 func getGroupsHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var groups []Group
 	var body []byte
 	var group Group
 
 	au := authenticatedUser(c)
-	if au.Admin == true {
-		if err := group.FindAll(au, &groups); err != nil {
-			log.Println(err)
-		}
-	} else {
-		if err := group.FindByID(au.GroupID); err != nil {
-			log.Println(err)
-		}
-		groups = append(groups, group)
+	if au.Admin != true {
+		return ErrUnauthorized
+	}
+
+	if err := group.FindAll(ctx, au, &groups); err != nil {
+		logError(c, err)
+		return err
 	}
 
 	if body, err = json.Marshal(groups); err != nil {
@@ -42,11 +40,12 @@ func getGroupsHandler(c echo.Context) (err error) {
 // getGroupHandler : responds to GET /groups/:id:/ with the specified
 // group details
 func getGroupHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var g Group
 	var body []byte
 
 	id, _ := strconv.Atoi(c.Param("group"))
-	if err := g.FindByID(id); err != nil {
+	if err := g.FindByID(ctx, id); err != nil {
 		return err
 	}
 
@@ -60,6 +59,7 @@ func getGroupHandler(c echo.Context) (err error) {
 // createGroupHandler : responds to POST /groups/ by creating a group
 // on the data store
 func createGroupHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var g Group
 	var existing Group
 	var body []byte
@@ -72,12 +72,12 @@ func createGroupHandler(c echo.Context) (err error) {
 		return ErrBadReqBody
 	}
 
-	if err := existing.FindByName(g.Name, &existing); err == nil {
+	if err := existing.FindByName(ctx, g.Name, &existing); err == nil {
 		return echo.NewHTTPError(409, "Specified group already exists")
 	}
 
-	if err = g.Save(); err != nil {
-		log.Println(err)
+	if err = g.Save(ctx); err != nil {
+		logError(c, err)
 	}
 
 	if body, err = json.Marshal(g); err != nil {
@@ -90,6 +90,7 @@ func createGroupHandler(c echo.Context) (err error) {
 // updateGroupHandler : responds to PUT /groups/:id: by updating an existing
 // group
 func updateGroupHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var g Group
 	var existing Group
 	var body []byte
@@ -103,12 +104,12 @@ func updateGroupHandler(c echo.Context) (err error) {
 		return ErrUnauthorized
 	}
 
-	if err := existing.FindByName(g.Name, &existing); err != nil {
+	if err := existing.FindByName(ctx, g.Name, &existing); err != nil {
 		return echo.NewHTTPError(404, "Specified group does not exists")
 	}
 
-	if err = g.Save(); err != nil {
-		log.Println(err)
+	if err = g.Save(ctx); err != nil {
+		logError(c, err)
 	}
 
 	if body, err = json.Marshal(g); err != nil {
@@ -121,6 +122,7 @@ func updateGroupHandler(c echo.Context) (err error) {
 // deleteGroupHandler : responds to DELETE /groups/:id: by deleting an
 // existing group
 func deleteGroupHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var g Group
 	var users []User
 	var datacenters []Datacenter
@@ -132,12 +134,12 @@ func deleteGroupHandler(c echo.Context) (err error) {
 	}
 
 	id, err := strconv.Atoi(c.Param("group"))
-	if err = g.FindByID(id); err != nil {
+	if err = g.FindByID(ctx, id); err != nil {
 		return err
 	}
 
 	// Check if there are any users on the group
-	if users, err = g.Users(); err != nil {
+	if users, err = g.Users(ctx); err != nil {
 		return err
 	}
 
@@ -146,7 +148,7 @@ func deleteGroupHandler(c echo.Context) (err error) {
 	}
 
 	// Check if there are any datacenters on the group
-	if datacenters, err = g.Datacenters(); err != nil {
+	if datacenters, err = g.Datacenters(ctx); err != nil {
 		return err
 	}
 
@@ -154,7 +156,7 @@ func deleteGroupHandler(c echo.Context) (err error) {
 		return echo.NewHTTPError(400, "This group has datacenters assigned to it, please remove the datacenters before performing this action")
 	}
 
-	if err := g.Delete(); err != nil {
+	if err := g.Delete(ctx); err != nil {
 		return err
 	}
 
@@ -163,6 +165,7 @@ func deleteGroupHandler(c echo.Context) (err error) {
 
 // deleteUserFromGroupHandler : Deletes an user from a group
 func deleteUserFromGroupHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var user User
 	au := authenticatedUser(c)
 
@@ -170,13 +173,13 @@ func deleteUserFromGroupHandler(c echo.Context) error {
 		return ErrUnauthorized
 	}
 
-	if err := user.FindByID(c.Param("user"), &user); err != nil {
-		log.Println(err)
+	if err := user.FindByID(ctx, c.Param("user"), &user); err != nil {
+		logError(c, err)
 	}
 	user.GroupID = 0
 	user.Password = ""
 	user.Salt = ""
-	if err := user.Save(); err != nil {
+	if err := user.Save(ctx); err != nil {
 		return ErrGatewayTimeout
 	}
 
@@ -185,6 +188,7 @@ func deleteUserFromGroupHandler(c echo.Context) error {
 
 // addUserToGroupHandler : Adds an user to a group
 func addUserToGroupHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var group Group
 	var user User
 	var payload map[string]string
@@ -195,7 +199,7 @@ func addUserToGroupHandler(c echo.Context) error {
 		return ErrUnauthorized
 	}
 
-	if err := group.FindByName(c.Param("group"), &group); err != nil {
+	if err := group.FindByName(ctx, c.Param("group"), &group); err != nil {
 		return ErrBadReqBody
 	}
 
@@ -210,14 +214,14 @@ func addUserToGroupHandler(c echo.Context) error {
 		return ErrBadReqBody
 	}
 
-	if err := user.FindByUserName(payload["username"], &user); err != nil {
+	if err := user.FindByUserName(ctx, payload["username"], &user); err != nil {
 		return err
 	}
 
 	user.GroupID = group.ID
 	user.Password = ""
 	user.Salt = ""
-	if err := user.Save(); err != nil {
+	if err := user.Save(ctx); err != nil {
 		return err
 	}
 
@@ -226,6 +230,7 @@ func addUserToGroupHandler(c echo.Context) error {
 
 // addDatacenterToGroupHandler : Adds a datacenter to a group
 func addDatacenterToGroupHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var group Group
 	var datacenter Datacenter
 	var payload map[string]string
@@ -256,17 +261,17 @@ func addDatacenterToGroupHandler(c echo.Context) error {
 		return ErrBadReqBody
 	}
 
-	if err := group.FindByID(groupID); err != nil {
+	if err := group.FindByID(ctx, groupID); err != nil {
 		return ErrBadReqBody
 	}
 
-	if err := datacenter.FindByID(datacenterID); err != nil {
+	if err := datacenter.FindByID(ctx, datacenterID); err != nil {
 		return ErrBadReqBody
 	}
 
 	datacenter.GroupID = groupID
-	if err = datacenter.Save(); err != nil {
-		log.Println(err)
+	if err = datacenter.Save(ctx); err != nil {
+		logError(c, err)
 	}
 
 	return c.JSONBlob(http.StatusOK, []byte("Datacenter successfully added to group "+group.Name))
@@ -274,6 +279,7 @@ func addDatacenterToGroupHandler(c echo.Context) error {
 
 // deleteDatacenterFromGroupHandler : Deletes a datacenter from a group
 func deleteDatacenterFromGroupHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var group Group
 	var datacenter Datacenter
 
@@ -284,18 +290,18 @@ func deleteDatacenterFromGroupHandler(c echo.Context) error {
 	}
 
 	groupid, err := strconv.Atoi(c.Param("group"))
-	if err = group.FindByID(groupid); err != nil {
+	if err = group.FindByID(ctx, groupid); err != nil {
 		return err
 	}
 
 	datacenterid, err := strconv.Atoi(c.Param("datacenter"))
-	if err = datacenter.FindByID(datacenterid); err != nil {
+	if err = datacenter.FindByID(ctx, datacenterid); err != nil {
 		return err
 	}
 
 	datacenter.GroupID = 0
-	if err = datacenter.Save(); err != nil {
-		log.Println(err)
+	if err = datacenter.Save(ctx); err != nil {
+		logError(c, err)
 	}
 
 	return c.JSONBlob(http.StatusOK, []byte("Datacenter successfully removed from group "+group.Name))
@@ -36,6 +36,17 @@ func TestGroups(t *testing.T) {
 			SkipConvey("Given no groups on the store", func() {
 			})
 		})
+
+		Convey("Given I am not an admin", func() {
+			Convey("When I call /groups/", func() {
+				ft := generateTestToken(1, "test", false)
+				_, err := doRequest("GET", "/groups/", nil, nil, getGroupsHandler, ft)
+
+				Convey("Then I should get a 403 error", func() {
+					So(err, ShouldEqual, ErrUnauthorized)
+				})
+			})
+		})
 	})
 
 	Convey("Scenario: getting a single group", t, func() {
@@ -0,0 +1,94 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// healthWeights : default weight of each factor on the datacenter health
+// score, it can be overridden with HEALTH_WEIGHT_<FACTOR>
+var healthWeights = map[string]int{
+	"credentials": 50,
+	"enabled":     30,
+	"services":    20,
+}
+
+// Health holds the derived health of a datacenter
+type Health struct {
+	Score   int            `json:"score"`
+	Factors []HealthFactor `json:"factors"`
+}
+
+// HealthFactor holds how a datacenter property contributes to its health
+type HealthFactor struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Weight int    `json:"weight"`
+	Score  int    `json:"score"`
+}
+
+// healthWeight : returns the configured weight for the given factor
+func healthWeight(factor string) int {
+	if v := os.Getenv("HEALTH_WEIGHT_" + strings.ToUpper(factor)); v != "" {
+		if w, err := strconv.Atoi(v); err == nil && w >= 0 {
+			return w
+		}
+	}
+
+	return healthWeights[factor]
+}
+
+// datacenterHealth : computes a 0-100 health score for the datacenter from
+// its credential status, enabled state and the state of its services
+func datacenterHealth(d Datacenter, services []Service, servicesErr error) (h Health) {
+	credentials := HealthFactor{Name: "credentials", Weight: healthWeight("credentials")}
+	credentials.Status = credentialStatuses.Get(d.ID)
+	switch credentials.Status {
+	case CredentialsOK:
+		credentials.Score = credentials.Weight
+	case CredentialsUnknown:
+		credentials.Score = credentials.Weight / 2
+	}
+
+	enabled := HealthFactor{Name: "enabled", Weight: healthWeight("enabled"), Status: "disabled"}
+	if d.IsEnabled() {
+		enabled.Status = "enabled"
+		enabled.Score = enabled.Weight
+	}
+
+	svcs := HealthFactor{Name: "services", Weight: healthWeight("services")}
+	if servicesErr != nil {
+		svcs.Status = "unknown"
+		svcs.Score = svcs.Weight / 2
+	} else {
+		healthy := 0
+		for _, s := range services {
+			if s.Status != "errored" {
+				healthy++
+			}
+		}
+		svcs.Status = strconv.Itoa(healthy) + "/" + strconv.Itoa(len(services)) + " healthy"
+		svcs.Score = svcs.Weight
+		if len(services) > 0 {
+			svcs.Score = svcs.Weight * healthy / len(services)
+		}
+	}
+
+	h.Factors = []HealthFactor{credentials, enabled, svcs}
+
+	total, score := 0, 0
+	for _, f := range h.Factors {
+		total += f.Weight
+		score += f.Score
+	}
+	if total > 0 {
+		h.Score = score * 100 / total
+	}
+
+	return h
+}
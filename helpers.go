@@ -7,6 +7,7 @@ package main
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
@@ -21,10 +22,16 @@ var (
 	ErrBadReqBody = echo.NewHTTPError(http.StatusBadRequest, "")
 	// ErrGatewayTimeout : HTTP 504 error
 	ErrGatewayTimeout = echo.NewHTTPError(http.StatusGatewayTimeout, "")
+	// ErrServiceUnavailable : HTTP 503 error
+	ErrServiceUnavailable = echo.NewHTTPError(http.StatusServiceUnavailable, "Backend is not available, try again later")
 	// ErrInternal : HTTP 500 error
 	ErrInternal = echo.NewHTTPError(http.StatusInternalServerError, "")
 	// ErrNotImplemented : HTTP 405 error
 	ErrNotImplemented = echo.NewHTTPError(http.StatusNotImplemented, "")
+	// ErrPayloadTooLarge : HTTP 413 error
+	ErrPayloadTooLarge = echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Request is too large to be stored, try reducing its size (e.g. less tags)")
+	// ErrBodyTooLarge : HTTP 413 error
+	ErrBodyTooLarge = echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Request body is too large")
 	// ErrExists : HTTP Error
 	ErrExists = echo.NewHTTPError(http.StatusSeeOther, "")
 )
@@ -40,6 +47,21 @@ func authenticatedUser(c echo.Context) User {
 		u.Username = claims["username"].(string)
 		u.GroupID = int(claims["group_id"].(float64))
 		u.Admin = claims["admin"].(bool)
+		if roles, ok := claims["roles"].([]interface{}); ok {
+			u.Roles = []string{}
+			for _, r := range roles {
+				if role, ok := r.(string); ok {
+					u.Roles = append(u.Roles, role)
+				}
+			}
+		}
+		if groups, ok := claims["groups"].([]interface{}); ok {
+			for _, g := range groups {
+				if id, ok := g.(float64); ok {
+					u.Groups = append(u.Groups, int(id))
+				}
+			}
+		}
 	}
 
 	return u
@@ -98,3 +120,24 @@ func getSearchFilter(c echo.Context) map[string]interface{} {
 
 	return query
 }
+
+// bulkResponse : responds to a bulk operation with the result of each item,
+// as a 207 when only some of them failed. When all items failed it returns
+// a 400 aggregating their errors instead
+func bulkResponse(c echo.Context, results interface{}, errs []string) error {
+	failed := []string{}
+	for _, e := range errs {
+		if e != "" {
+			failed = append(failed, e)
+		}
+	}
+
+	if len(errs) > 0 && len(failed) == len(errs) {
+		return echo.NewHTTPError(http.StatusBadRequest, "All items failed: "+strings.Join(failed, "; "))
+	}
+	if len(failed) > 0 {
+		return c.JSON(http.StatusMultiStatus, results)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
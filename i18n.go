@@ -0,0 +1,120 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultLocale : locale used when the requested one is not available
+const DefaultLocale = "en"
+
+var messages = map[string]map[string]string{
+	"en": {
+		"datacenter.name.empty":                       "Datacenter name is empty",
+		"datacenter.name.invalid":                     "Datacenter name must be 3 to 63 lowercase letters, digits or hyphens, not starting nor ending with a hyphen",
+		"datacenter.type.empty":                       "Datacenter type is empty",
+		"datacenter.type.unsupported":                 "Datacenter type is unsupported, supported types are aws, azure, gcp and vcloud",
+		"datacenter.username.empty":                   "Datacenter username is empty",
+		"datacenter.password.empty":                   "Datacenter password is empty",
+		"datacenter.aws_access_key_id.empty":          "Datacenter aws access key id is empty",
+		"datacenter.aws_secret_access_key.empty":      "Datacenter aws secret access key is empty",
+		"datacenter.vcloud_url.empty":                 "Datacenter vcloud url is empty",
+		"datacenter.azure_subscription_id.empty":      "Datacenter azure subscription id is empty",
+		"datacenter.azure_client_id.empty":            "Datacenter azure client id is empty",
+		"datacenter.azure_client_secret.empty":        "Datacenter azure client secret is empty",
+		"datacenter.azure_tenant_id.empty":            "Datacenter azure tenant id is empty",
+		"datacenter.gcp_project_id.empty":             "Datacenter gcp project id is empty",
+		"datacenter.gcp_service_account_json.empty":   "Datacenter gcp service account json is empty",
+		"datacenter.gcp_service_account_json.invalid": "Datacenter gcp service account json is not a valid service account key",
+		"datacenter.tags.missing":                     "Datacenter is missing a required tag",
+	},
+	"es": {
+		"datacenter.name.empty":                       "El nombre del datacenter está vacío",
+		"datacenter.name.invalid":                     "El nombre del datacenter debe tener de 3 a 63 letras minúsculas, dígitos o guiones, sin empezar ni terminar con un guion",
+		"datacenter.type.empty":                       "El tipo del datacenter está vacío",
+		"datacenter.type.unsupported":                 "El tipo del datacenter no está soportado, los tipos soportados son aws, azure, gcp y vcloud",
+		"datacenter.username.empty":                   "El usuario del datacenter está vacío",
+		"datacenter.password.empty":                   "La contraseña del datacenter está vacía",
+		"datacenter.aws_access_key_id.empty":          "El access key id de aws del datacenter está vacío",
+		"datacenter.aws_secret_access_key.empty":      "El secret access key de aws del datacenter está vacío",
+		"datacenter.vcloud_url.empty":                 "La url de vcloud del datacenter está vacía",
+		"datacenter.azure_subscription_id.empty":      "El subscription id de azure del datacenter está vacío",
+		"datacenter.azure_client_id.empty":            "El client id de azure del datacenter está vacío",
+		"datacenter.azure_client_secret.empty":        "El client secret de azure del datacenter está vacío",
+		"datacenter.azure_tenant_id.empty":            "El tenant id de azure del datacenter está vacío",
+		"datacenter.gcp_project_id.empty":             "El project id de gcp del datacenter está vacío",
+		"datacenter.gcp_service_account_json.empty":   "El json de la service account de gcp del datacenter está vacío",
+		"datacenter.gcp_service_account_json.invalid": "El json de la service account de gcp del datacenter no es una clave de service account válida",
+		"datacenter.tags.missing":                     "Al datacenter le falta una etiqueta obligatoria",
+	},
+}
+
+// ValidationError : validation failure identified by its message code
+type ValidationError struct {
+	Code string
+}
+
+// Error : returns the validation message on the default locale
+func (e ValidationError) Error() string {
+	return translate(e.Code, DefaultLocale)
+}
+
+// translate : returns the message for the given code and locale, falling
+// back to the default locale and then to the code itself
+func translate(code, locale string) string {
+	if msg, ok := messages[locale][code]; ok {
+		return msg
+	}
+	if msg, ok := messages[DefaultLocale][code]; ok {
+		return msg
+	}
+	return code
+}
+
+// requestLocale : picks the preferred available locale from an
+// Accept-Language header value
+func requestLocale(header string) string {
+	locale := DefaultLocale
+	best := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		tag = strings.Split(tag, "-")[0]
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if _, ok := messages[tag]; ok && q > best {
+			locale = tag
+			best = q
+		}
+	}
+
+	return locale
+}
+
+// httpErrorHandler : localizes validation errors to the request's
+// Accept-Language before rendering them as any other error
+func httpErrorHandler(err error, c echo.Context) {
+	if ve, ok := err.(ValidationError); ok {
+		locale := requestLocale(c.Request().Header.Get("Accept-Language"))
+		err = echo.NewHTTPError(http.StatusBadRequest, translate(ve.Code, locale))
+	}
+
+	renderError(err, c)
+}
@@ -0,0 +1,59 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalizedErrors(t *testing.T) {
+	Convey("Scenario: creating an invalid datacenter", t, func() {
+		data := []byte(`{"type":"aws","aws_access_key_id":"key","aws_secret_access_key":"secret"}`)
+		_, err := doRequest("POST", "/datacenters/", nil, data, createDatacenterHandler, nil)
+
+		Convey("Then the handler should return a validation error", func() {
+			So(err, ShouldResemble, ValidationError{Code: "datacenter.name.empty"})
+
+			Convey("When the error is rendered for a spanish speaking client", func() {
+				e := echo.New()
+				req, _ := http.NewRequest("POST", "/datacenters/", nil)
+				req.Header.Set("Accept-Language", "es-ES,es;q=0.9,en;q=0.8")
+				rec := httptest.NewRecorder()
+				c := e.NewContext(req, echo.NewResponse(rec, e))
+
+				httpErrorHandler(err, c)
+
+				Convey("Then the message should be localized", func() {
+					var body ErrorEnvelope
+					So(rec.Code, ShouldEqual, 400)
+					So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+					So(body.Error, ShouldEqual, "El nombre del datacenter está vacío")
+					So(body.Code, ShouldEqual, 400)
+				})
+			})
+		})
+	})
+
+	Convey("Scenario: picking the locale of a request", t, func() {
+		Convey("Given no supported locale is requested", func() {
+			Convey("Then it should fall back to english", func() {
+				So(requestLocale(""), ShouldEqual, "en")
+				So(requestLocale("fr-FR,de;q=0.5"), ShouldEqual, "en")
+			})
+		})
+
+		Convey("Given several supported locales are requested", func() {
+			Convey("Then the one with the highest quality should win", func() {
+				So(requestLocale("en;q=0.4,es;q=0.8"), ShouldEqual, "es")
+			})
+		})
+	})
+}
@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+// jwtAlgorithms : returns the signing algorithms accepted on tokens, as
+// configured on JWT_ALGORITHMS, defaulting to the one used to sign them
+func jwtAlgorithms() []string {
+	algs := []string{}
+	for _, alg := range strings.Split(os.Getenv("JWT_ALGORITHMS"), ",") {
+		if alg = strings.TrimSpace(alg); alg != "" {
+			algs = append(algs, alg)
+		}
+	}
+	if len(algs) == 0 {
+		algs = append(algs, jwt.SigningMethodHS256.Alg())
+	}
+
+	return algs
+}
+
+// validIssuerAndAudience : checks the token iss and aud claims match the
+// JWT_ISSUER and JWT_AUDIENCE, when set. The audience may be a list
+func validIssuerAndAudience(claims jwt.MapClaims) bool {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		if v, _ := claims["iss"].(string); v != iss {
+			return false
+		}
+	}
+
+	aud := os.Getenv("JWT_AUDIENCE")
+	if aud == "" {
+		return true
+	}
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBootstrapKey : checks if the given bearer is the admin key configured
+// on BOOTSTRAP_ADMIN_KEY for the initial setup
+func isBootstrapKey(bearer string) bool {
+	key := os.Getenv("BOOTSTRAP_ADMIN_KEY")
+	return key != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(key)) == 1
+}
+
+// bootstrapToken : returns the token of the admin granted by the bootstrap
+// key
+func bootstrapToken() *jwt.Token {
+	claims := make(jwt.MapClaims)
+	claims["group_id"] = float64(0)
+	claims["username"] = "bootstrap"
+	claims["admin"] = true
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+}
+
+// jwtAuth : middleware validating the bearer token on the Authorization
+// header and storing it as "user" on the context. The BOOTSTRAP_ADMIN_KEY,
+// when set, is accepted as a bearer granting admin access, and so are the
+// API_KEYS granting the access configured for them. Tokens not signed with
+// an hmac algorithm listed on JWT_ALGORITHMS are rejected, and so are the ones
+// without an expiry when JWT_REQUIRE_EXP is enabled or whose issuer or
+// audience don't match JWT_ISSUER and JWT_AUDIENCE. When JWT_EXP_SOFT is
+// enabled expired tokens are still accepted but logged and flagged on the
+// X-Token-Expired response header
+func jwtAuth(key []byte) echo.MiddlewareFunc {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		// the key is a shared secret, so only hmac methods can be trusted
+		// whatever JWT_ALGORITHMS allows
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected jwt signing method=%v", t.Header["alg"])
+		}
+		for _, alg := range jwtAlgorithms() {
+			if t.Method.Alg() == alg {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("Unexpected jwt signing method=%v", t.Header["alg"])
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if !strings.HasPrefix(auth, "Bearer ") || len(auth) <= len("Bearer ") {
+				return echo.NewHTTPError(http.StatusBadRequest, "Missing or invalid jwt in the request header")
+			}
+
+			if isBootstrapKey(auth[len("Bearer "):]) {
+				log.Println("WARNING: request authenticated with BOOTSTRAP_ADMIN_KEY, remove it once onboarding is done")
+				c.Set("user", bootstrapToken())
+				return next(c)
+			}
+
+			if name, k, ok := findAPIKey(auth[len("Bearer "):]); ok {
+				c.Set("user", k.Token())
+				c.Set("api_key", name)
+				return next(c)
+			}
+
+			token, err := jwt.Parse(auth[len("Bearer "):], keyFunc)
+			if err == nil && token.Valid {
+				claims, _ := token.Claims.(jwt.MapClaims)
+				if _, ok := claims["exp"]; !ok && os.Getenv("JWT_REQUIRE_EXP") == "true" {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token has no expiry")
+				}
+				if !validIssuerAndAudience(claims) {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token issuer or audience is not accepted")
+				}
+				c.Set("user", token)
+				return next(c)
+			}
+
+			if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors == jwt.ValidationErrorExpired {
+				if os.Getenv("JWT_EXP_SOFT") != "true" {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token is expired")
+				}
+				claims, _ := token.Claims.(jwt.MapClaims)
+				if !validIssuerAndAudience(claims) {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token issuer or audience is not accepted")
+				}
+				log.Println("WARNING: accepting expired token for user", claims["username"])
+				c.Response().Header().Set("X-Token-Expired", "true")
+				c.Set("user", token)
+				return next(c)
+			}
+
+			return echo.ErrUnauthorized
+		}
+	}
+}
+
+// requireGroup : middleware rejecting authenticated users without a group
+// when REQUIRE_GROUP is enabled
+func requireGroup(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if os.Getenv("REQUIRE_GROUP") == "true" && authenticatedUser(c).GroupID == 0 {
+			return echo.NewHTTPError(http.StatusForbidden, "User does not belong to any group")
+		}
+		return next(c)
+	}
+}
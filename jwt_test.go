@@ -0,0 +1,333 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func okHandler(c echo.Context) error {
+	return c.String(http.StatusOK, "ok")
+}
+
+func signTestToken(ft *jwt.Token) map[string]string {
+	t, _ := ft.SignedString([]byte("test"))
+	return map[string]string{"Authorization": "Bearer " + t}
+}
+
+func TestJWTAuth(t *testing.T) {
+	h := handle(jwtAuth([]byte("test"))(okHandler))
+
+	expired := generateTestToken(1, "test", false)
+	expired.Claims.(jwt.MapClaims)["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	Convey("Scenario: authenticating requests with a jwt", t, func() {
+		Convey("Given a valid token", func() {
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(generateTestToken(1, "test", false)))
+			Convey("Then the request should be allowed", func() {
+				So(err, ShouldBeNil)
+				So(rec.Body.String(), ShouldEqual, "ok")
+			})
+		})
+
+		Convey("Given a token signed with an unexpected algorithm", func() {
+			ft := jwt.NewWithClaims(jwt.SigningMethodHS512, generateTestToken(1, "test", false).Claims)
+
+			Convey("When only the default algorithm is allowed", func() {
+				_ = os.Unsetenv("JWT_ALGORITHMS")
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(ft))
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+			})
+
+			Convey("When the algorithm is allowed", func() {
+				_ = os.Setenv("JWT_ALGORITHMS", "HS256, HS512")
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(ft))
+				Convey("Then the request should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+				})
+
+				Reset(func() {
+					_ = os.Unsetenv("JWT_ALGORITHMS")
+				})
+			})
+		})
+
+		Convey("Given a token without signature", func() {
+			ft := jwt.NewWithClaims(jwt.SigningMethodNone, generateTestToken(1, "test", false).Claims)
+			token, _ := ft.SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+			Convey("When none is listed as an allowed algorithm", func() {
+				_ = os.Setenv("JWT_ALGORITHMS", "HS256,none")
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, map[string]string{"Authorization": "Bearer " + token})
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+
+				Reset(func() {
+					_ = os.Unsetenv("JWT_ALGORITHMS")
+				})
+			})
+		})
+
+		Convey("Given a token signed with an rsa key", func() {
+			key, _ := rsa.GenerateKey(rand.Reader, 2048)
+			ft := jwt.NewWithClaims(jwt.SigningMethodRS256, generateTestToken(1, "test", false).Claims)
+			token, _ := ft.SignedString(key)
+
+			Convey("When RS256 is listed as an allowed algorithm", func() {
+				_ = os.Setenv("JWT_ALGORITHMS", "HS256,RS256")
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, map[string]string{"Authorization": "Bearer " + token})
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+
+				Reset(func() {
+					_ = os.Unsetenv("JWT_ALGORITHMS")
+				})
+			})
+		})
+
+		Convey("Given an issuer and audience are required", func() {
+			_ = os.Setenv("JWT_ISSUER", "https://auth.example.com")
+			_ = os.Setenv("JWT_AUDIENCE", "gateway")
+			withClaims := func(iss, aud interface{}) map[string]string {
+				ft := generateTestToken(1, "test", false)
+				claims := ft.Claims.(jwt.MapClaims)
+				if iss != nil {
+					claims["iss"] = iss
+				}
+				if aud != nil {
+					claims["aud"] = aud
+				}
+				return signTestToken(ft)
+			}
+
+			Convey("When the token claims match", func() {
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, withClaims("https://auth.example.com", "gateway"))
+				Convey("Then the request should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+				})
+			})
+
+			Convey("When the token audience is a list including the gateway", func() {
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, withClaims("https://auth.example.com", []string{"billing", "gateway"}))
+				Convey("Then the request should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+				})
+			})
+
+			Convey("When the token issuer doesn't match", func() {
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, withClaims("https://evil.example.com", "gateway"))
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+			})
+
+			Convey("When the token audience doesn't match", func() {
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, withClaims("https://auth.example.com", "billing"))
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+			})
+
+			Convey("When the token has no issuer nor audience", func() {
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, withClaims(nil, nil))
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("JWT_ISSUER")
+				_ = os.Unsetenv("JWT_AUDIENCE")
+			})
+		})
+
+		Convey("Given no issuer nor audience are required", func() {
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(generateTestToken(1, "test", false)))
+			Convey("Then tokens without them should be allowed", func() {
+				So(err, ShouldBeNil)
+				So(rec.Body.String(), ShouldEqual, "ok")
+			})
+		})
+
+		Convey("Given the bootstrap admin key", func() {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			_ = os.Setenv("BOOTSTRAP_ADMIN_KEY", "bootstrap-secret")
+			adminHandler := handle(jwtAuth([]byte("test"))(func(c echo.Context) error {
+				return c.String(http.StatusOK, strconv.FormatBool(authenticatedUser(c).Admin))
+			}))
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, adminHandler, generateTestToken(1, "test", false), map[string]string{"Authorization": "Bearer bootstrap-secret"})
+
+			Convey("Then the request should be granted admin access with a warning", func() {
+				So(err, ShouldBeNil)
+				So(rec.Body.String(), ShouldEqual, "true")
+				So(buf.String(), ShouldContainSubstring, "WARNING: request authenticated with BOOTSTRAP_ADMIN_KEY")
+			})
+
+			Reset(func() {
+				log.SetOutput(os.Stderr)
+				_ = os.Unsetenv("BOOTSTRAP_ADMIN_KEY")
+			})
+		})
+
+		Convey("Given no token", func() {
+			_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+			Convey("Then the request should be rejected", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(*echo.HTTPError).Code, ShouldEqual, 400)
+			})
+		})
+
+		Convey("Given an expired token", func() {
+			Convey("When expiry is enforced", func() {
+				_ = os.Unsetenv("JWT_EXP_SOFT")
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(expired))
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+			})
+
+			Convey("When expiry is on soft mode", func() {
+				_ = os.Setenv("JWT_EXP_SOFT", "true")
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(expired))
+				Convey("Then the request should be allowed and flagged", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+					So(rec.Header().Get("X-Token-Expired"), ShouldEqual, "true")
+				})
+
+				Reset(func() {
+					_ = os.Unsetenv("JWT_EXP_SOFT")
+				})
+			})
+		})
+	})
+}
+
+func TestRequireGroup(t *testing.T) {
+	h := handle(requireGroup(okHandler))
+
+	Convey("Scenario: enforcing a group on authenticated users", t, func() {
+		Convey("Given a user without a group", func() {
+			ft := generateTestToken(0, "test", false)
+
+			Convey("When the group is not required", func() {
+				_ = os.Unsetenv("REQUIRE_GROUP")
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, ft, nil)
+				Convey("Then the request should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+				})
+			})
+
+			Convey("When the group is required", func() {
+				_ = os.Setenv("REQUIRE_GROUP", "true")
+				_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, ft, nil)
+				Convey("Then the request should be forbidden", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 403)
+				})
+
+				Reset(func() {
+					_ = os.Unsetenv("REQUIRE_GROUP")
+				})
+			})
+		})
+
+		Convey("Given a user with a group", func() {
+			_ = os.Setenv("REQUIRE_GROUP", "true")
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, generateTestToken(1, "test", false), nil)
+			Convey("Then the request should be allowed", func() {
+				So(err, ShouldBeNil)
+				So(rec.Body.String(), ShouldEqual, "ok")
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("REQUIRE_GROUP")
+			})
+		})
+	})
+}
+
+func TestJWTExpiry(t *testing.T) {
+	h := handle(jwtAuth([]byte("test"))(okHandler))
+
+	Convey("Scenario: enforcing the expiry of tokens", t, func() {
+		ft := jwt.New(jwt.SigningMethodHS256)
+		claims := ft.Claims.(jwt.MapClaims)
+		claims["username"] = "test"
+		claims["group_id"] = float64(1)
+		claims["admin"] = false
+
+		Convey("Given a token expiring in the future", func() {
+			claims["exp"] = time.Now().Add(time.Hour).Unix()
+			resp, err := doRequestHeaders("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(ft))
+			Convey("Then the request should be allowed", func() {
+				So(err, ShouldBeNil)
+				So(string(resp), ShouldEqual, "ok")
+			})
+		})
+
+		Convey("Given a token which already expired", func() {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			_, err := doRequestHeaders("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(ft))
+			Convey("Then the request should be rejected as expired", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				So(err.(*echo.HTTPError).Message, ShouldEqual, "Token is expired")
+			})
+		})
+
+		Convey("Given a token without expiry", func() {
+			Convey("When the expiry is not required", func() {
+				_ = os.Unsetenv("JWT_REQUIRE_EXP")
+				resp, err := doRequestHeaders("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(ft))
+				Convey("Then the request should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(string(resp), ShouldEqual, "ok")
+				})
+			})
+
+			Convey("When the expiry is required", func() {
+				_ = os.Setenv("JWT_REQUIRE_EXP", "true")
+				_, err := doRequestHeaders("GET", "/api/datacenters/", nil, nil, h, nil, signTestToken(ft))
+				Convey("Then the request should be rejected", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+
+				Reset(func() {
+					_ = os.Unsetenv("JWT_REQUIRE_EXP")
+				})
+			})
+		})
+	})
+}
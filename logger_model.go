@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -50,27 +51,27 @@ func (l *Logger) Map(c echo.Context) *echo.HTTPError {
 }
 
 // FindAll : Searches for all loggers on the system
-func (l *Logger) FindAll(loggers *[]Logger) (err error) {
+func (l *Logger) FindAll(ctx context.Context, loggers *[]Logger) (err error) {
 	query := make(map[string]interface{})
-	if err := NewBaseModel("logger").FindBy(query, loggers); err != nil {
+	if err := NewBaseModel("logger").FindBy(ctx, query, loggers); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Save : calls logger.set with the marshalled current logger
-func (l *Logger) Save() (err error) {
-	if err := NewBaseModel("logger").Save(l); err != nil {
+func (l *Logger) Save(ctx context.Context) (err error) {
+	if err := NewBaseModel("logger").Save(ctx, l); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Delete : will delete a logger by its type
-func (l *Logger) Delete() (err error) {
+func (l *Logger) Delete(ctx context.Context) (err error) {
 	query := make(map[string]interface{})
 	query["type"] = l.Type
-	if err := NewBaseModel("logger").Delete(query); err != nil {
+	if err := NewBaseModel("logger").Delete(ctx, query); err != nil {
 		return err
 	}
 	return nil
@@ -14,6 +14,7 @@ import (
 // getLoggersHandler : responds to GET /loggers/ with a list of all
 // loggers
 func getLoggersHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var loggers []Logger
 	var body []byte
 	var logger Logger
@@ -22,7 +23,7 @@ func getLoggersHandler(c echo.Context) (err error) {
 		return ErrUnauthorized
 	}
 
-	if err = logger.FindAll(&loggers); err != nil {
+	if err = logger.FindAll(ctx, &loggers); err != nil {
 		return err
 	}
 
@@ -35,6 +36,7 @@ func getLoggersHandler(c echo.Context) (err error) {
 // createLoggerHandler : responds to POST /loggers/ by creating a logger
 // on the data store
 func createLoggerHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var l Logger
 	var body []byte
 
@@ -46,8 +48,8 @@ func createLoggerHandler(c echo.Context) (err error) {
 		return ErrBadReqBody
 	}
 
-	if err = l.Save(); err != nil {
-		return c.JSONBlob(400, []byte(err.Error()))
+	if err = l.Save(ctx); err != nil {
+		return httpError(400, err.Error())
 	}
 
 	if body, err = json.Marshal(l); err != nil {
@@ -59,6 +61,7 @@ func createLoggerHandler(c echo.Context) (err error) {
 // deleteLoggerHandler : responds to DELETE /loggers/:id: by deleting an
 // existing logger
 func deleteLoggerHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var l Logger
 
 	if authenticatedUser(c).Admin != true {
@@ -69,7 +72,7 @@ func deleteLoggerHandler(c echo.Context) (err error) {
 		return ErrBadReqBody
 	}
 
-	if err := l.Delete(); err != nil {
+	if err := l.Delete(ctx); err != nil {
 		return err
 	}
 
@@ -6,6 +6,8 @@ package main
 
 import (
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -17,20 +19,40 @@ var secret string
 
 func main() {
 	log.Println("starting gateway")
+	if _, _, err := tlsFiles(); err != nil {
+		panic(err)
+	}
+	if !encryptionEnabled() {
+		log.Println("WARNING: ENCRYPTION_KEY is not set, datacenter credentials will be stored as plaintext")
+	}
 	setup()
+	if err := setupTracing(); err != nil {
+		panic("Invalid tracing configuration: " + err.Error())
+	}
 
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.HTTPErrorHandler = httpErrorHandler
+	e.Use(requestLogger)
+	e.Use(instrument)
 	e.Use(middleware.Recover())
-	e.POST("/auth", authenticate)
-	e.GET("/status", getStatusHandler)
+	e.Use(cors)
+	e.Use(bodyLimit)
+	e.Use(compress)
+	e.Use(bodyLogger)
+	e.Use(debugTimings)
+	e.Use(deprecation)
+	e.Use(tracing)
+	setupServer(e)
+
+	if interval := envPositiveInt("RECONCILE_INTERVAL", 0); interval > 0 {
+		startReconciliation(time.Duration(interval) * time.Second)
+	}
 
-	// Setup JWT auth & protected routes
-	api := e.Group("/api")
-	api.Use(middleware.JWT([]byte(secret)))
-	setupRoutes(api)
+	go func() {
+		if err := start(e, ":8080"); err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
+	}()
 
-	if err := e.Start(":8080"); err != nil {
-		panic(err)
-	}
+	waitForShutdown(e)
 }
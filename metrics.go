@@ -0,0 +1,169 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// natsLatencyBuckets : upper bounds in seconds of the NATS request latency
+// histogram buckets
+var natsLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+var metrics = newMetricsRegistry()
+
+// requestKey identifies the requests counted together
+type requestKey struct {
+	handler string
+	method  string
+	status  int
+}
+
+// histogram holds the cumulative bucket counts of the observed values
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// metricsRegistry keeps the gateway metrics exposed on /metrics
+type metricsRegistry struct {
+	sync.Mutex
+	requests map[requestKey]uint64
+	inFlight int64
+	nats     map[string]*histogram
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		requests: make(map[requestKey]uint64),
+		nats:     make(map[string]*histogram),
+	}
+}
+
+// Begin : counts a request as in flight
+func (m *metricsRegistry) Begin() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.inFlight++
+}
+
+// End : counts a served request by handler, method and status
+func (m *metricsRegistry) End(handler, method string, status int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.inFlight--
+	m.requests[requestKey{handler, method, status}]++
+}
+
+// ObserveNATS : records the latency of a NATS request to the given subject
+func (m *metricsRegistry) ObserveNATS(subject string, elapsed time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	h, ok := m.nats[subject]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(natsLatencyBuckets))}
+		m.nats[subject] = h
+	}
+
+	s := elapsed.Seconds()
+	for i, le := range natsLatencyBuckets {
+		if s <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+// WriteTo : writes the metrics on the Prometheus text exposition format
+func (m *metricsRegistry) WriteTo(buf *bytes.Buffer) {
+	m.Lock()
+	defer m.Unlock()
+
+	keys := []requestKey{}
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	buf.WriteString("# HELP gateway_http_requests_total Requests served by handler, method and status.\n")
+	buf.WriteString("# TYPE gateway_http_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "gateway_http_requests_total{handler=%q,method=%q,status=\"%d\"} %d\n", k.handler, k.method, k.status, m.requests[k])
+	}
+
+	buf.WriteString("# HELP gateway_http_requests_in_flight Requests being served.\n")
+	buf.WriteString("# TYPE gateway_http_requests_in_flight gauge\n")
+	fmt.Fprintf(buf, "gateway_http_requests_in_flight %d\n", m.inFlight)
+
+	subjects := []string{}
+	for s := range m.nats {
+		subjects = append(subjects, s)
+	}
+	sort.Strings(subjects)
+
+	buf.WriteString("# HELP gateway_nats_request_duration_seconds Latency of the NATS requests by subject.\n")
+	buf.WriteString("# TYPE gateway_nats_request_duration_seconds histogram\n")
+	for _, s := range subjects {
+		h := m.nats[s]
+		for i, le := range natsLatencyBuckets {
+			fmt.Fprintf(buf, "gateway_nats_request_duration_seconds_bucket{subject=%q,le=%q} %d\n", s, strconv.FormatFloat(le, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(buf, "gateway_nats_request_duration_seconds_bucket{subject=%q,le=\"+Inf\"} %d\n", s, h.count)
+		fmt.Fprintf(buf, "gateway_nats_request_duration_seconds_sum{subject=%q} %g\n", s, h.sum)
+		fmt.Fprintf(buf, "gateway_nats_request_duration_seconds_count{subject=%q} %d\n", s, h.count)
+	}
+}
+
+// instrument : middleware counting the requests served by each handler,
+// labelled with its route rather than the requested path
+func instrument(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		metrics.Begin()
+
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = errorStatus(err)
+		}
+		handler := c.Path()
+		if handler == "" {
+			handler = "unmatched"
+		}
+		metrics.End(handler, c.Request().Method, status)
+
+		return err
+	}
+}
+
+// getMetricsHandler : responds to GET /metrics with the gateway metrics on
+// the Prometheus text exposition format
+func getMetricsHandler(c echo.Context) error {
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
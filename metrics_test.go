@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	testsSetup()
+	setup()
+
+	Convey("Scenario: scraping the gateway metrics", t, func() {
+		e := echo.New()
+		e.Use(instrument)
+		setupServer(e)
+
+		Convey("Given some requests have been served", func() {
+			So(serve(e, "GET", "/healthz").Code, ShouldEqual, 200)
+			So(serve(e, "GET", "/api/datacenters/").Code, ShouldEqual, 400)
+
+			Convey("When I call GET /metrics without credentials", func() {
+				rec := serve(e, "GET", "/metrics")
+
+				Convey("Then the requests should be counted by handler and status", func() {
+					So(rec.Code, ShouldEqual, 200)
+					So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+					body := rec.Body.String()
+					So(body, ShouldContainSubstring, "# TYPE gateway_http_requests_total counter")
+					So(body, ShouldContainSubstring, `gateway_http_requests_total{handler="/healthz",method="GET",status="200"}`)
+					So(body, ShouldContainSubstring, `gateway_http_requests_total{handler="/api/datacenters/",method="GET",status="400"}`)
+					So(body, ShouldContainSubstring, "gateway_http_requests_in_flight 1")
+				})
+			})
+		})
+
+		Convey("Given a NATS request has been sent", func() {
+			sub, _ := n.Subscribe("metrics.ping", func(msg *nats.Msg) {
+				_ = n.Publish(msg.Reply, []byte("pong"))
+			})
+			_, err := request(context.Background(), "metrics.ping", nil, time.Second)
+			So(err, ShouldBeNil)
+			_ = sub.Unsubscribe()
+
+			Convey("When I call GET /metrics", func() {
+				body := serve(e, "GET", "/metrics").Body.String()
+
+				Convey("Then its latency should be on the histogram", func() {
+					So(body, ShouldContainSubstring, "# TYPE gateway_nats_request_duration_seconds histogram")
+					So(body, ShouldContainSubstring, `gateway_nats_request_duration_seconds_bucket{subject="metrics.ping",le="+Inf"}`)
+					So(body, ShouldContainSubstring, `gateway_nats_request_duration_seconds_count{subject="metrics.ping"}`)
+				})
+			})
+		})
+	})
+}
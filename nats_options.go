@@ -0,0 +1,118 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// DefaultNatsReconnectWait : time to wait between reconnection attempts
+// when NATS_RECONNECT_WAIT is not set
+const DefaultNatsReconnectWait = 2 * time.Second
+
+// errNatsDisconnected : returned instead of sending requests while the NATS
+// connection is down
+var errNatsDisconnected = errors.New("NATS connection is not available")
+
+// natsOptions : builds the nats connection options from the environment,
+// supporting tls and token, user/password, nkey or credentials file auth
+func natsOptions() ([]nats.Option, error) {
+	var opts []nats.Option
+	authenticated := false
+
+	if token := os.Getenv("NATS_TOKEN"); token != "" {
+		opts = append(opts, nats.Token(token))
+		authenticated = true
+	}
+
+	user := os.Getenv("NATS_USER")
+	password := os.Getenv("NATS_PASSWORD")
+	if user != "" || password != "" {
+		if user == "" || password == "" {
+			return nil, errors.New("Both NATS_USER and NATS_PASSWORD must be configured")
+		}
+		opts = append(opts, nats.UserInfo(user, password))
+		authenticated = true
+	}
+
+	if seed := os.Getenv("NATS_NKEY_SEED_FILE"); seed != "" {
+		opt, err := nats.NkeyOptionFromSeed(seed)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+		authenticated = true
+	}
+
+	if creds := os.Getenv("NATS_CREDS_FILE"); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+		authenticated = true
+	}
+
+	if ca := os.Getenv("NATS_TLS_CA"); ca != "" {
+		opts = append(opts, nats.RootCAs(ca))
+	}
+
+	cert := os.Getenv("NATS_TLS_CERT")
+	key := os.Getenv("NATS_TLS_KEY")
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, errors.New("Both NATS_TLS_CERT and NATS_TLS_KEY must be configured")
+		}
+		opts = append(opts, nats.ClientCert(cert, key))
+	}
+
+	if os.Getenv("NATS_AUTH_REQUIRED") == "true" && !authenticated {
+		return nil, errors.New("NATS authentication is required but no NATS_TOKEN, NATS_USER/NATS_PASSWORD, NATS_NKEY_SEED_FILE or NATS_CREDS_FILE is configured")
+	}
+
+	return opts, nil
+}
+
+// natsReconnectWait : returns the time to wait between reconnection
+// attempts, as configured on NATS_RECONNECT_WAIT with a duration like "5s"
+func natsReconnectWait() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("NATS_RECONNECT_WAIT")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultNatsReconnectWait
+}
+
+// natsReconnectOptions : keeps reconnecting to NATS for as long as the
+// gateway runs, logging the connection events
+func natsReconnectOptions() []nats.Option {
+	return []nats.Option{
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectWait()),
+		nats.DisconnectHandler(func(nc *nats.Conn) {
+			log.Println("Disconnected from NATS, reconnecting")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Println("Reconnected to NATS at " + nc.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			log.Println("NATS connection closed")
+		}),
+	}
+}
+
+// natsConnected : checks the NATS connection can currently be used
+func natsConnected() bool {
+	return n != nil && n.IsConnected()
+}
+
+// publish : publishes the message on the given subject, failing right away
+// while NATS is disconnected instead of buffering it
+func publish(subject string, data []byte) error {
+	if !natsConnected() {
+		return errNatsDisconnected
+	}
+	return n.Publish(subject, data)
+}
@@ -0,0 +1,168 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func applyNatsOptions(opts []nats.Option) nats.Options {
+	o := nats.GetDefaultOptions()
+	for _, opt := range opts {
+		_ = opt(&o)
+	}
+	return o
+}
+
+func TestNatsOptions(t *testing.T) {
+	vars := []string{"NATS_TOKEN", "NATS_USER", "NATS_PASSWORD", "NATS_NKEY_SEED_FILE", "NATS_CREDS_FILE", "NATS_TLS_CA", "NATS_TLS_CERT", "NATS_TLS_KEY", "NATS_AUTH_REQUIRED"}
+	reset := func() {
+		for _, v := range vars {
+			_ = os.Unsetenv(v)
+		}
+	}
+
+	Convey("Scenario: configuring the nats connection", t, func() {
+		reset()
+
+		Convey("Given no auth is configured", func() {
+			opts, err := natsOptions()
+			Convey("Then it should connect without credentials", func() {
+				So(err, ShouldBeNil)
+				So(len(opts), ShouldEqual, 0)
+			})
+
+			Convey("And auth is required", func() {
+				_ = os.Setenv("NATS_AUTH_REQUIRED", "true")
+				_, err := natsOptions()
+				Convey("Then it should fail", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("Given a token is configured", func() {
+			_ = os.Setenv("NATS_TOKEN", "secret")
+			opts, err := natsOptions()
+			Convey("Then it should be used to authenticate", func() {
+				So(err, ShouldBeNil)
+				So(applyNatsOptions(opts).Token, ShouldEqual, "secret")
+			})
+		})
+
+		Convey("Given a user and password are configured", func() {
+			_ = os.Setenv("NATS_USER", "gateway")
+			_ = os.Setenv("NATS_PASSWORD", "secret")
+			_ = os.Setenv("NATS_AUTH_REQUIRED", "true")
+			opts, err := natsOptions()
+			Convey("Then they should be used to authenticate", func() {
+				So(err, ShouldBeNil)
+				o := applyNatsOptions(opts)
+				So(o.User, ShouldEqual, "gateway")
+				So(o.Password, ShouldEqual, "secret")
+			})
+		})
+
+		Convey("Given only a user is configured", func() {
+			_ = os.Setenv("NATS_USER", "gateway")
+			_, err := natsOptions()
+			Convey("Then it should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given a credentials file is configured", func() {
+			_ = os.Setenv("NATS_CREDS_FILE", "/etc/nats/gateway.creds")
+			opts, err := natsOptions()
+			Convey("Then it should be used to authenticate", func() {
+				So(err, ShouldBeNil)
+				o := applyNatsOptions(opts)
+				So(o.UserJWT, ShouldNotBeNil)
+				So(o.SignatureCB, ShouldNotBeNil)
+			})
+		})
+
+		Reset(reset)
+	})
+
+	Convey("Scenario: reconnecting to nats", t, func() {
+		Convey("Given no reconnect wait is configured", func() {
+			o := applyNatsOptions(natsReconnectOptions())
+
+			Convey("Then it should keep reconnecting with the default wait", func() {
+				So(o.MaxReconnect, ShouldEqual, -1)
+				So(o.ReconnectWait, ShouldEqual, DefaultNatsReconnectWait)
+				So(o.DisconnectedCB, ShouldNotBeNil)
+				So(o.ReconnectedCB, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given a reconnect wait is configured", func() {
+			_ = os.Setenv("NATS_RECONNECT_WAIT", "5s")
+			o := applyNatsOptions(natsReconnectOptions())
+
+			Convey("Then it should wait that long between attempts", func() {
+				So(o.ReconnectWait, ShouldEqual, 5*time.Second)
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("NATS_RECONNECT_WAIT")
+			})
+		})
+	})
+
+	Convey("Scenario: serving requests while nats is disconnected", t, func() {
+		testsSetup()
+		setup()
+
+		Convey("Given the nats connection is closed", func() {
+			connected := n
+			nc, err := nats.Connect(os.Getenv("NATS_URI"))
+			So(err, ShouldBeNil)
+			nc.Close()
+			n = nc
+
+			Convey("When I call a handler querying the stores", func() {
+				params := make(map[string]string)
+				params["datacenter"] = "1"
+				start := time.Now()
+				_, err := doRequest("GET", "/datacenters/:datacenter", params, nil, getDatacenterHandler, nil)
+
+				Convey("Then it should fail fast with a 503 error", func() {
+					So(err, ShouldEqual, ErrServiceUnavailable)
+					So(time.Since(start), ShouldBeLessThan, time.Second)
+				})
+			})
+
+			Convey("When I call POST /services/", func() {
+				headers := map[string]string{"Content-Type": "application/json"}
+				data := []byte(`{"name":"test","datacenter":"test"}`)
+				_, err := doRequestHeaders("POST", "/services/", nil, data, createServiceHandler, nil, headers)
+
+				Convey("Then it should fail with a 503 error", func() {
+					So(err, ShouldEqual, ErrServiceUnavailable)
+				})
+			})
+
+			Convey("When I force the deletion of a service", func() {
+				params := map[string]string{"name": "test"}
+				_, err := doRequest("DELETE", "/services/:name/force/", params, nil, forceServiceDeletionHandler, nil)
+
+				Convey("Then it should fail with a 503 error", func() {
+					So(err, ShouldEqual, ErrServiceUnavailable)
+				})
+			})
+
+			Reset(func() {
+				n = connected
+			})
+		})
+	})
+}
@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultPageLimit : number of items returned per page when no limit
+// is requested
+const DefaultPageLimit = 20
+
+// encodeCursor : builds an opaque cursor pointing after the given id
+func encodeCursor(id int) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+// decodeCursor : returns the last seen id encoded on the cursor, an empty
+// cursor points to the beginning of the list
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	id, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(id))
+}
+
+// usesCursor : checks if the request asks for cursor based pagination
+func usesCursor(c echo.Context) bool {
+	_, ok := c.QueryParams()["cursor"]
+	return ok
+}
+
+// pageLimit : returns the requested page size or the default one
+func pageLimit(c echo.Context) int {
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 {
+		return DefaultPageLimit
+	}
+
+	return limit
+}
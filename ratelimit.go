@@ -0,0 +1,136 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// RateLimitCleanupInterval : how often idle user buckets are dropped
+const RateLimitCleanupInterval = 10 * time.Minute
+
+// bucket holds the tokens left to a client and when they were refilled
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps in memory a token bucket per client, refilled at rate
+// tokens per second up to burst tokens
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func newRateLimiter(rate float64, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take : consumes a token from the client bucket, when none is left it
+// returns the time until the next one is available
+func (l *rateLimiter) Take(client string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// Cleanup : drops the buckets of the clients idle for longer than the
+// given time, as they would be full again anyway
+func (l *rateLimiter) Cleanup(idle time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > idle {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// startCleanup : drops the idle buckets of the limiter every interval
+func (l *rateLimiter) startCleanup(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			l.Cleanup(interval)
+		}
+	}()
+}
+
+// rateLimitKey : returns the bucket key of an authenticated user, so users
+// with the same name on different groups are limited separately
+func rateLimitKey(au User) string {
+	return au.Username + "@" + strconv.Itoa(au.GroupID)
+}
+
+// retryAfter : returns the whole seconds a client has to wait, never less
+// than one
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+// rateLimit : middleware limiting the requests of each authenticated user,
+// rejected requests get a Retry-After header with the seconds until the
+// user bucket has a token again
+func rateLimit(l *rateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ok, wait := l.Take(rateLimitKey(authenticatedUser(c)))
+			if !ok {
+				c.Response().Header().Set("Retry-After", retryAfter(wait))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "Rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
+
+// rateLimitConfig : returns the requests per second and burst configured
+// on RATE_LIMIT_RPS, or its former name RATE_LIMIT, and RATE_LIMIT_BURST,
+// the burst defaults to the rate
+func rateLimitConfig() (rate float64, burst float64) {
+	v := os.Getenv("RATE_LIMIT_RPS")
+	if v == "" {
+		v = os.Getenv("RATE_LIMIT")
+	}
+	rate, _ = strconv.ParseFloat(v, 64)
+	burst, _ = strconv.ParseFloat(os.Getenv("RATE_LIMIT_BURST"), 64)
+	if burst < 1 {
+		burst = math.Max(1, rate)
+	}
+
+	return rate, burst
+}
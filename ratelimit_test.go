@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimit(t *testing.T) {
+	Convey("Scenario: rate limiting requests", t, func() {
+		now := time.Now()
+		l := newRateLimiter(0.25, 1)
+		l.now = func() time.Time { return now }
+		h := handle(rateLimit(l)(okHandler))
+
+		Convey("Given the user bucket is empty", func() {
+			_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+			So(err, ShouldBeNil)
+
+			Convey("When the user does another request", func() {
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+				Convey("Then it should wait for the whole bucket refill", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 429)
+					So(rec.Header().Get("Retry-After"), ShouldEqual, "4")
+				})
+			})
+
+			Convey("When the user does another request after the bucket partially refilled", func() {
+				now = now.Add(2500 * time.Millisecond)
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+				Convey("Then it should wait for the rest of the refill", func() {
+					So(err, ShouldNotBeNil)
+					So(rec.Header().Get("Retry-After"), ShouldEqual, "2")
+				})
+			})
+
+			Convey("When a user with the same name on another group does a request", func() {
+				ft := generateTestToken(2, "admin", true)
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, ft, nil)
+
+				Convey("Then it should be allowed on its own bucket", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+				})
+			})
+
+			Convey("When the user does another request after the bucket refilled", func() {
+				now = now.Add(4 * time.Second)
+				rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+				Convey("Then the request should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(rec.Body.String(), ShouldEqual, "ok")
+				})
+			})
+		})
+	})
+
+	Convey("Scenario: cleaning up idle buckets", t, func() {
+		now := time.Now()
+		l := newRateLimiter(1, 5)
+		l.now = func() time.Time { return now }
+
+		Convey("Given a user burst through the limit", func() {
+			for i := 0; i < 5; i++ {
+				ok, _ := l.Take("test@1")
+				So(ok, ShouldBeTrue)
+			}
+			ok, _ := l.Take("test@1")
+			So(ok, ShouldBeFalse)
+			l.Take("other@2")
+
+			Convey("When the buckets are cleaned up after the user stays idle", func() {
+				now = now.Add(time.Minute)
+				l.Cleanup(30 * time.Second)
+
+				Convey("Then idle buckets should be dropped", func() {
+					So(len(l.buckets), ShouldEqual, 0)
+				})
+			})
+
+			Convey("When the buckets are cleaned up while in use", func() {
+				l.Cleanup(30 * time.Second)
+
+				Convey("Then they should be kept", func() {
+					So(len(l.buckets), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+}
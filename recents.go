@@ -0,0 +1,41 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import "sync"
+
+// RecentDatacentersLimit : number of recently viewed datacenters kept
+// per user
+const RecentDatacentersLimit = 10
+
+var recentDatacenters = &recents{viewed: make(map[string][]int)}
+
+// recents keeps track in memory of the last datacenters each user viewed
+type recents struct {
+	sync.Mutex
+	viewed map[string][]int
+}
+
+// Add : registers the datacenter as the most recently viewed by the user
+func (r *recents) Add(username string, id int) {
+	r.Lock()
+	defer r.Unlock()
+
+	ids := []int{id}
+	for _, v := range r.viewed[username] {
+		if v != id && len(ids) < RecentDatacentersLimit {
+			ids = append(ids, v)
+		}
+	}
+	r.viewed[username] = ids
+}
+
+// Get : returns the datacenters viewed by the user, most recent first
+func (r *recents) Get(username string) []int {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]int{}, r.viewed[username]...)
+}
@@ -0,0 +1,65 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// reconcile : refreshes the cached credential status of all datacenters,
+// logging the ones which drifted from the backend and forgetting the ones
+// of datacenters which no longer exist
+func reconcile() {
+	ctx := context.Background()
+	var datacenter Datacenter
+	var datacenters []Datacenter
+
+	if err := datacenter.FindAll(ctx, User{Admin: true}, &datacenters); err != nil {
+		log.Println("Reconciliation failed: " + err.Error())
+		return
+	}
+
+	timeout := time.Duration(envPositiveInt("VALIDATE_TIMEOUT", DefaultVerifyTimeout)) * time.Second
+	existing := make(map[int]bool)
+	for _, d := range datacenters {
+		existing[d.ID] = true
+		cached := credentialStatuses.Get(d.ID)
+		r := validateCredentials(ctx, d, timeout)
+		if cached != CredentialsUnknown && cached != r.Status {
+			log.Println("Reconciliation: datacenter " + strconv.Itoa(d.ID) + " credential status was " + cached + " but is " + r.Status)
+		}
+	}
+
+	for _, id := range credentialStatuses.IDs() {
+		if !existing[id] {
+			log.Println("Reconciliation: datacenter " + strconv.Itoa(id) + " no longer exists")
+			credentialStatuses.Delete(id)
+		}
+	}
+}
+
+// startReconciliation : runs the reconciliation every interval until the
+// returned channel is closed
+func startReconciliation(interval time.Duration) chan struct{} {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reconcile()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcile(t *testing.T) {
+	testsSetup()
+	setup()
+
+	Convey("Scenario: reconciling the cached credential statuses", t, func() {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		Convey("Given cached statuses drifted from the backend", func() {
+			credentialStatuses.Set(7, CredentialsOK)
+			credentialStatuses.Set(99, CredentialsOK)
+			foundSubscriber("datacenter.find", `[{"id":7,"name":"drifted","type":"aws"}]`, 1)
+			foundSubscriber("datacenter.verify", `{"reachable":false,"message":"invalid credentials"}`, 1)
+
+			Convey("When the reconciliation runs", func() {
+				reconcile()
+
+				Convey("Then the cached statuses should be updated", func() {
+					So(credentialStatuses.Get(7), ShouldEqual, CredentialsError)
+					So(credentialStatuses.Get(99), ShouldEqual, CredentialsUnknown)
+				})
+
+				Convey("Then the discrepancies should be logged", func() {
+					So(buf.String(), ShouldContainSubstring, "datacenter 7 credential status was ok but is error")
+					So(buf.String(), ShouldContainSubstring, "datacenter 99 no longer exists")
+				})
+			})
+
+			Reset(func() {
+				credentialStatuses.Delete(7)
+				credentialStatuses.Delete(99)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stderr)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/nu7hatch/gouuid"
+)
+
+// logFormat : returns the format of the log lines, as configured on
+// LOG_FORMAT with "json" or "text"
+func logFormat() string {
+	if os.Getenv("LOG_FORMAT") == "json" {
+		return "json"
+	}
+	return "text"
+}
+
+// logLine : writes the given fields as a json object when LOG_FORMAT is
+// json, or as key=value pairs otherwise
+func logLine(fields map[string]interface{}) {
+	if logFormat() == "json" {
+		data, err := json.Marshal(fields)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		log.Println(string(data))
+		return
+	}
+
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, fields[k]))
+	}
+	log.Println(strings.Join(pairs, " "))
+}
+
+// requestID : returns the id assigned to the request
+func requestID(c echo.Context) string {
+	id, _ := c.Get("request_id").(string)
+	return id
+}
+
+// requestUser : returns the username of the request token if any
+func requestUser(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		username, _ := claims["username"].(string)
+		return username
+	}
+	return ""
+}
+
+// logError : logs the error with the request id attached
+func logError(c echo.Context, err error) {
+	logLine(map[string]interface{}{
+		"request_id": requestID(c),
+		"error":      err.Error(),
+	})
+}
+
+// requestIDFormat : request ids accepted from clients, longer ids or ids
+// with other characters are replaced so they can't forge log lines
+var requestIDFormat = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestLogger : middleware assigning each request an id, returned on the
+// X-Request-ID header, and logging it once served
+func requestLogger(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get("X-Request-ID")
+		if !requestIDFormat.MatchString(id) {
+			if u, err := uuid.NewV4(); err == nil {
+				id = u.String()
+			}
+		}
+		c.Set("request_id", id)
+		c.Response().Header().Set("X-Request-ID", id)
+
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = errorStatus(err)
+		}
+		logLine(map[string]interface{}{
+			"request_id": id,
+			"method":     c.Request().Method,
+			"path":       c.Request().URL.Path,
+			"status":     status,
+			"latency_ms": time.Since(start).Nanoseconds() / int64(time.Millisecond),
+			"user":       requestUser(c),
+		})
+
+		return err
+	}
+}
@@ -0,0 +1,105 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func failingHandler(c echo.Context) error {
+	logError(c, errors.New("store unavailable"))
+	return ErrGatewayTimeout
+}
+
+func invalidHandler(c echo.Context) error {
+	return ValidationError{Code: "datacenter.name.empty"}
+}
+
+func TestRequestLogger(t *testing.T) {
+	Convey("Scenario: logging requests with an id", t, func() {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		log.SetFlags(0)
+
+		Convey("Given the log format is json", func() {
+			_ = os.Setenv("LOG_FORMAT", "json")
+			h := handle(requestLogger(failingHandler))
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+			Convey("Then each line should be a json object with the request id", func() {
+				So(err, ShouldEqual, ErrGatewayTimeout)
+				id := rec.Header().Get("X-Request-ID")
+				So(id, ShouldNotEqual, "")
+
+				lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+				So(len(lines), ShouldEqual, 2)
+
+				var failure, request map[string]interface{}
+				So(json.Unmarshal([]byte(lines[0]), &failure), ShouldBeNil)
+				So(failure["request_id"], ShouldEqual, id)
+				So(failure["error"], ShouldEqual, "store unavailable")
+
+				So(json.Unmarshal([]byte(lines[1]), &request), ShouldBeNil)
+				So(request["request_id"], ShouldEqual, id)
+				So(request["method"], ShouldEqual, "GET")
+				So(request["path"], ShouldEqual, "/api/datacenters/")
+				So(request["status"], ShouldEqual, float64(504))
+				So(request["user"], ShouldEqual, "admin")
+			})
+		})
+
+		Convey("Given the log format is not set", func() {
+			h := handle(requestLogger(okHandler))
+			headers := map[string]string{"X-Request-ID": "abc-123"}
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+
+			Convey("Then the given request id should be logged as text", func() {
+				So(err, ShouldBeNil)
+				So(rec.Header().Get("X-Request-ID"), ShouldEqual, "abc-123")
+				So(buf.String(), ShouldContainSubstring, "request_id=abc-123")
+				So(buf.String(), ShouldContainSubstring, "path=/api/datacenters/")
+			})
+		})
+
+		Convey("Given the handler fails with a validation error", func() {
+			h := handle(requestLogger(invalidHandler))
+			_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+			Convey("Then the status sent by the error handler should be logged", func() {
+				So(err, ShouldNotBeNil)
+				So(buf.String(), ShouldContainSubstring, "status=400")
+			})
+		})
+
+		Convey("Given the request id has a line break", func() {
+			h := handle(requestLogger(okHandler))
+			headers := map[string]string{"X-Request-ID": "abc\nstatus=200"}
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+
+			Convey("Then a new request id should be assigned", func() {
+				So(err, ShouldBeNil)
+				id := rec.Header().Get("X-Request-ID")
+				So(id, ShouldNotContainSubstring, "abc")
+				So(buf.String(), ShouldContainSubstring, "request_id="+id)
+				So(strings.Count(buf.String(), "\n"), ShouldEqual, 1)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stderr)
+			log.SetFlags(log.LstdFlags)
+			_ = os.Unsetenv("LOG_FORMAT")
+		})
+	})
+}
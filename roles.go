@@ -0,0 +1,79 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	// RoleViewer : can read the resources of its group
+	RoleViewer = "viewer"
+	// RoleOperator : can also create, update and delete them
+	RoleOperator = "operator"
+	// RoleOwner : can do anything on its group
+	RoleOwner = "owner"
+)
+
+// roleRanks : each role grants the roles with a lower or equal rank
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleOwner:    3,
+}
+
+// legacyRoles : roles of the tokens issued without a roles claim, which
+// keep the access members had before roles existed
+var legacyRoles = []string{RoleViewer, RoleOperator}
+
+// HasRole : checks if the user has the given role or a higher one, admins
+// have every role
+func (u *User) HasRole(role string) bool {
+	if u.Admin {
+		return true
+	}
+
+	roles := u.Roles
+	if roles == nil {
+		roles = legacyRoles
+	}
+
+	for _, r := range roles {
+		if rank, ok := roleRanks[r]; ok && rank >= roleRanks[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// requireRole : middleware rejecting with a 403 the users without any of
+// the given roles
+func requireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			au := authenticatedUser(c)
+			for _, role := range roles {
+				if au.HasRole(role) {
+					return next(c)
+				}
+			}
+			return ErrUnauthorized
+		}
+	}
+}
+
+// requireGroupMember : middleware rejecting with a 401 the users without a
+// group, admins are allowed without one
+func requireGroupMember(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		au := authenticatedUser(c)
+		if au.Admin != true && len(au.GroupIDs()) == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Current user does not belong to any group.\nPlease assign the user to a group before performing this action")
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,192 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRoles(t *testing.T) {
+	testsSetup()
+	setup()
+
+	Convey("Scenario: checking the roles of a user", t, func() {
+		Convey("Given an admin", func() {
+			u := User{Admin: true}
+
+			Convey("Then it should have every role", func() {
+				So(u.HasRole(RoleOwner), ShouldBeTrue)
+			})
+		})
+
+		Convey("Given an operator", func() {
+			u := User{Roles: []string{RoleOperator}}
+
+			Convey("Then it should have the lower roles only", func() {
+				So(u.HasRole(RoleViewer), ShouldBeTrue)
+				So(u.HasRole(RoleOperator), ShouldBeTrue)
+				So(u.HasRole(RoleOwner), ShouldBeFalse)
+			})
+		})
+
+		Convey("Given a user issued without roles", func() {
+			u := User{}
+
+			Convey("Then it should keep the access of a member", func() {
+				So(u.HasRole(RoleOperator), ShouldBeTrue)
+				So(u.HasRole(RoleOwner), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Scenario: restricting datacenter fields by group role", t, func() {
+		_ = os.Setenv("DATACENTER_FIELD_ACL", `{"viewer":{"read":["id","name"]},"member":{"read":["id","name","type"]}}`)
+
+		Convey("Given users with different roles", func() {
+			viewer := User{GroupID: 1, Roles: []string{RoleViewer}}
+			operator := User{GroupID: 1, Roles: []string{RoleViewer, RoleOperator}}
+			legacy := User{GroupID: 1}
+			admin := User{Admin: true, Roles: []string{RoleViewer}}
+
+			Convey("Then their highest role should be used on the field ACL", func() {
+				So(viewer.Role(), ShouldEqual, RoleViewer)
+				So(operator.Role(), ShouldEqual, RoleOperator)
+				So(legacy.Role(), ShouldEqual, "member")
+				So(admin.Role(), ShouldEqual, "admin")
+			})
+
+			Convey("When a datacenter is redacted for a viewer", func() {
+				d := Datacenter{ID: 1, Name: "test", Type: "aws"}
+				d.Redact(viewer)
+
+				Convey("Then the viewer ACL should apply", func() {
+					So(d.Name, ShouldEqual, "test")
+					So(d.Type, ShouldEqual, "")
+				})
+			})
+
+			Convey("When a datacenter is redacted for a role without its own ACL", func() {
+				d := Datacenter{ID: 1, Name: "test", Type: "aws", Region: "eu-west-1"}
+				d.Redact(operator)
+
+				Convey("Then the member ACL should apply", func() {
+					So(d.Type, ShouldEqual, "aws")
+					So(d.Region, ShouldEqual, "")
+				})
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("DATACENTER_FIELD_ACL")
+		})
+	})
+
+	Convey("Scenario: creating a datacenter requiring the operator role", t, func() {
+		h := handle(requireRole(RoleOperator)(createDatacenterHandler))
+		data := []byte(`{"name":"new-role","type":"aws","region":"eu-west-1","aws_access_key_id":"key","aws_secret_access_key":"secret"}`)
+
+		Convey("Given I am a viewer", func() {
+			ft := generateTestTokenWithRoles(1, "test", RoleViewer)
+
+			Convey("When I do a post to /datacenters/", func() {
+				_, err := doRequest("POST", "/datacenters/", nil, data, h, ft)
+
+				Convey("Then I should get a 403 error", func() {
+					So(err, ShouldEqual, ErrUnauthorized)
+				})
+			})
+		})
+
+		Convey("Given I am an operator", func() {
+			findDatacenterSubscriber(1)
+			createDatacenterSubscriber()
+			getGroupSubscriber()
+			ft := generateTestTokenWithRoles(1, "test", RoleOperator)
+
+			Convey("When I do a post to /datacenters/", func() {
+				resp, err := doRequest("POST", "/datacenters/", nil, data, h, ft)
+
+				Convey("Then the datacenter should be created", func() {
+					var d Datacenter
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &d)
+					So(err, ShouldBeNil)
+					So(d.ID, ShouldEqual, 3)
+				})
+			})
+		})
+	})
+
+	Convey("Scenario: changing datacenters without a group", t, func() {
+		params := map[string]string{"datacenter": "1"}
+
+		Convey("Given I am not an admin and have no group", func() {
+			ft := generateTestToken(0, "test", false)
+
+			Convey("When I do a put to /datacenters/:datacenter", func() {
+				_, err := doRequest("PUT", "/datacenters/:datacenter", params, []byte(`{"name":"test"}`), handle(requireGroupMember(updateDatacenterHandler)), ft)
+
+				Convey("Then I should get a 401 error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+					So(err.(*echo.HTTPError).Message, ShouldContainSubstring, "does not belong to any group")
+				})
+			})
+
+			Convey("When I do a delete to /datacenters/:datacenter", func() {
+				_, err := doRequest("DELETE", "/datacenters/:datacenter", params, nil, handle(requireGroupMember(deleteDatacenterHandler)), ft)
+
+				Convey("Then I should get a 401 error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 401)
+				})
+			})
+
+			Convey("When I do a post to any of the datacenter routes", func() {
+				e := echo.New()
+				setupRoutes(e.Group("/api", func(next echo.HandlerFunc) echo.HandlerFunc {
+					return func(c echo.Context) error {
+						c.Set("user", ft)
+						return next(c)
+					}
+				}))
+				codes := make(map[string]int)
+				for _, r := range e.Routes() {
+					if r.Method == "POST" && strings.HasPrefix(r.Path, "/api/datacenters/") && !strings.HasSuffix(r.Path, "*") {
+						codes[r.Path] = serve(e, "POST", strings.Replace(r.Path, ":datacenter", "1", 1)).Code
+					}
+				}
+
+				Convey("Then I should get a 401 error on each of them", func() {
+					So(codes, ShouldContainKey, "/api/datacenters/import/validate/")
+					So(codes, ShouldContainKey, "/api/datacenters/:datacenter/test")
+					for path, code := range codes {
+						So(path+" "+strconv.Itoa(code), ShouldEqual, path+" 401")
+					}
+				})
+			})
+		})
+
+		Convey("Given I am an admin without a group", func() {
+			ft := generateTestToken(0, "admin", true)
+
+			Convey("When I change a datacenter", func() {
+				resp, err := doRequest("DELETE", "/datacenters/:datacenter", params, nil, handle(requireGroupMember(okHandler)), ft)
+
+				Convey("Then I should be allowed", func() {
+					So(err, ShouldBeNil)
+					So(string(resp), ShouldEqual, "ok")
+				})
+			})
+		})
+	})
+}
@@ -5,9 +5,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"sort"
 	"time"
 
 	"github.com/labstack/echo"
@@ -91,6 +93,19 @@ type ServiceMapping struct {
 	} `json:"ebs_volumes"`
 }
 
+// ServicePage holds a page of services and the cursor to the next one
+type ServicePage struct {
+	Services   []Service `json:"services"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// ServiceDependency holds a resource a service depends on, as reported by
+// the service store
+type ServiceDependency struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
 // Validate the service
 func (s *Service) Validate() error {
 	if s.Name == "" {
@@ -130,18 +145,18 @@ func (s *Service) Map(c echo.Context) *echo.HTTPError {
 }
 
 // Find : Searches for all services with filters
-func (s *Service) Find(query map[string]interface{}, services *[]Service) (err error) {
-	if err := NewBaseModel("service").FindBy(query, services); err != nil {
+func (s *Service) Find(ctx context.Context, query map[string]interface{}, services *[]Service) (err error) {
+	if err := NewBaseModel("service").FindBy(ctx, query, services); err != nil {
 		return err
 	}
 	return nil
 }
 
 // FindByName : Searches for all services with a name equal to the specified
-func (s *Service) FindByName(name string, service *Service) (err error) {
+func (s *Service) FindByName(ctx context.Context, name string, service *Service) (err error) {
 	query := make(map[string]interface{})
 	query["name"] = name
-	if err := NewBaseModel("service").GetBy(query, service); err != nil {
+	if err := NewBaseModel("service").GetBy(ctx, query, service); err != nil {
 		return err
 	}
 	return nil
@@ -149,27 +164,27 @@ func (s *Service) FindByName(name string, service *Service) (err error) {
 
 // FindByGroupID : Searches for all services on the store current user
 // has access to with the specified group id
-func (s *Service) FindByGroupID(id int, services *[]Service) (err error) {
+func (s *Service) FindByGroupID(ctx context.Context, id int, services *[]Service) (err error) {
 	query := make(map[string]interface{})
 	query["group_id"] = id
 
-	return NewBaseModel("service").FindBy(query, services)
+	return NewBaseModel("service").FindBy(ctx, query, services)
 }
 
 // FindByNameAndGroupID : Searches for all services with a name equal to the specified
-func (s *Service) FindByNameAndGroupID(name string, id int, service *[]Service) (err error) {
+func (s *Service) FindByNameAndGroupID(ctx context.Context, name string, id int, service *[]Service) (err error) {
 	query := make(map[string]interface{})
 	query["name"] = name
 	query["group_id"] = id
 
-	return NewBaseModel("service").FindBy(query, service)
+	return NewBaseModel("service").FindBy(ctx, query, service)
 }
 
 // FindByID : Gets a model by its id
-func (s *Service) FindByID(id int) (err error) {
+func (s *Service) FindByID(ctx context.Context, id int) (err error) {
 	query := make(map[string]interface{})
 	query["id"] = id
-	if err := NewBaseModel("service").GetBy(query, s); err != nil {
+	if err := NewBaseModel("service").GetBy(ctx, query, s); err != nil {
 		return err
 	}
 	return nil
@@ -177,61 +192,102 @@ func (s *Service) FindByID(id int) (err error) {
 
 // FindAll : Searches for all groups on the store current user
 // has access to
-func (s *Service) FindAll(au User, services *[]Service) (err error) {
+func (s *Service) FindAll(ctx context.Context, au User, services *[]Service) (err error) {
 	query := make(map[string]interface{})
 	query["group_id"] = au.GroupID
-	if err := NewBaseModel("service").FindBy(query, services); err != nil {
+	if err := NewBaseModel("service").FindBy(ctx, query, services); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Save : calls service.set with the marshalled current group
-func (s *Service) Save() (err error) {
-	if err := NewBaseModel("service").Save(s); err != nil {
+func (s *Service) Save(ctx context.Context) (err error) {
+	if err := NewBaseModel("service").Save(ctx, s); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Delete : will delete a service by its id
-func (s *Service) Delete() (err error) {
+func (s *Service) Delete(ctx context.Context) (err error) {
 	query := make(map[string]interface{})
 	query["id"] = s.ID
-	if err := NewBaseModel("service").Delete(query); err != nil {
+	if err := NewBaseModel("service").Delete(ctx, query); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Mapping : will get a service mapping
-func (s *Service) Mapping() (m ServiceMapping, err error) {
+func (s *Service) Mapping(ctx context.Context) (m ServiceMapping, err error) {
 	query := make(map[string]interface{})
 	query["id"] = s.ID
 
-	err = NewBaseModel("service").callStoreBy("get.mapping", query, &m)
+	err = NewBaseModel("service").callStoreBy(ctx, "get.mapping", query, &m)
 
 	return m, err
 }
 
+// Dependencies : will get the resources the service depends on, including
+// the ones referenced by its own dependencies
+func (s *Service) Dependencies(ctx context.Context) (deps []ServiceDependency, err error) {
+	query := make(map[string]interface{})
+	query["id"] = s.ID
+
+	err = NewBaseModel("service").callStoreBy(ctx, "dependencies", query, &deps)
+
+	return deps, err
+}
+
 // Reset : will reset the service status to errored
-func (s *Service) Reset() (err error) {
+func (s *Service) Reset(ctx context.Context) (err error) {
 	s.Status = "errored"
 	query := make(map[string]interface{})
 	query["id"] = s.ID
 	query["status"] = "errored"
 
-	err = NewBaseModel("service").Set(query)
+	err = NewBaseModel("service").Set(ctx, query)
 
 	return err
 }
 
+// MoveTo : will point the service to the given datacenter
+func (s *Service) MoveTo(ctx context.Context, datacenterID int) (err error) {
+	s.DatacenterID = datacenterID
+	query := make(map[string]interface{})
+	query["id"] = s.ID
+	query["datacenter_id"] = datacenterID
+
+	return NewBaseModel("service").Set(ctx, query)
+}
+
 // FindByDatacenterID : find a services for the given datacenter id
-func (s *Service) FindByDatacenterID(id int, services *[]Service) (err error) {
+func (s *Service) FindByDatacenterID(ctx context.Context, id int, services *[]Service) (err error) {
 	query := make(map[string]interface{})
 	query["datacenter_id"] = id
-	if err := NewBaseModel("service").FindBy(query, services); err != nil {
+	if err := NewBaseModel("service").FindBy(ctx, query, services); err != nil {
 		return err
 	}
 	return nil
 }
+
+// PaginateServices : returns up to limit services sorted by id skipping the
+// first offset ones, and the cursor to the next page if any. Services ids
+// are not numeric so the cursor holds an offset instead of the last seen id
+func PaginateServices(services []Service, offset int, limit int) (page ServicePage) {
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+
+	page.Services = []Service{}
+	for i := offset; i < len(services); i++ {
+		if len(page.Services) == limit {
+			page.NextCursor = encodeCursor(i)
+			break
+		}
+		page.Services = append(page.Services, services[i])
+	}
+
+	return page
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 )
@@ -61,7 +62,7 @@ type ServiceRender struct {
 }
 
 // Render : Map a Service to a ServiceRender
-func (o *ServiceRender) Render(s Service) (err error) {
+func (o *ServiceRender) Render(ctx context.Context, s Service) (err error) {
 	var mapping ServiceMapping
 
 	o.ID = s.ID
@@ -76,7 +77,7 @@ func (o *ServiceRender) Render(s Service) (err error) {
 		o.Definition = def
 	}
 
-	if mapping, err = s.Mapping(); err != nil {
+	if mapping, err = s.Mapping(ctx); err != nil {
 		log.Println(err.Error())
 		return err
 	}
@@ -98,10 +99,10 @@ func (o *ServiceRender) Render(s Service) (err error) {
 }
 
 // RenderCollection : Maps a collection of Service on a collection of ServiceRender
-func (o *ServiceRender) RenderCollection(services []Service) (list []ServiceRender, err error) {
+func (o *ServiceRender) RenderCollection(ctx context.Context, services []Service) (list []ServiceRender, err error) {
 	for _, s := range services {
 		var output ServiceRender
-		if err := output.Render(s); err == nil {
+		if err := output.Render(ctx, s); err == nil {
 			list = append(list, output)
 		}
 	}
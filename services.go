@@ -7,7 +7,6 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -16,21 +15,26 @@ import (
 )
 
 // getServicesHandler : responds to GET /services/ with a list of all
-// services for current user group
+// services for current user group, optionally filtered by ?type=
 func getServicesHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var services []Service
 	var list []Service
 	var body []byte
 	var service Service
 	var user User
 
-	users := user.FindAllKeyValue()
+	users := user.FindAllKeyValue(ctx)
 
 	au := authenticatedUser(c)
-	if err := service.FindAll(au, &services); err != nil {
-		log.Println(err)
+	if err := service.FindAll(ctx, au, &services); err != nil {
+		logError(c, err)
 	}
+	serviceType := c.QueryParam("type")
 	for _, s := range services {
+		if serviceType != "" && s.Type != serviceType {
+			continue
+		}
 		exists := false
 		for i, e := range list {
 			if e.Name == s.Name {
@@ -59,9 +63,10 @@ func getServicesHandler(c echo.Context) (err error) {
 // getServiceBuildsHandler : gets the list of builds for the specified
 // service
 func getServiceBuildsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var user User
 
-	users := user.FindAllKeyValue()
+	users := user.FindAllKeyValue(ctx)
 	au := authenticatedUser(c)
 
 	query := getParamFilter(c)
@@ -69,9 +74,9 @@ func getServiceBuildsHandler(c echo.Context) error {
 		query["group_id"] = au.GroupID
 	}
 
-	list, err := getServicesOutput(query)
+	list, err := getServicesOutput(ctx, query)
 	if err != nil {
-		return c.JSONBlob(500, []byte(err.Error()))
+		return httpError(500, err.Error())
 	}
 	for i := range list {
 		for id, name := range users {
@@ -87,6 +92,7 @@ func getServiceBuildsHandler(c echo.Context) error {
 // getServiceHandler : responds to GET /services/:service with the
 // details of an existing service
 func getServiceHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var s Service
 	var services []Service
 	var o ServiceRender
@@ -98,25 +104,26 @@ func getServiceHandler(c echo.Context) (err error) {
 		query["group_id"] = au.GroupID
 	}
 
-	if err = s.Find(query, &services); err != nil {
-		return c.JSONBlob(500, []byte(err.Error()))
+	if err = s.Find(ctx, query, &services); err != nil {
+		return httpError(500, err.Error())
 	}
 
 	if len(services) > 0 {
-		if err := o.Render(services[0]); err != nil {
-			log.Println(err)
+		if err := o.Render(ctx, services[0]); err != nil {
+			logError(c, err)
 			return err
 		}
 		if body, err = o.ToJSON(); err != nil {
-			return c.JSONBlob(500, []byte(err.Error()))
+			return httpError(500, err.Error())
 		}
 		return c.JSONBlob(http.StatusOK, body)
 	}
-	return c.JSON(http.StatusNotFound, nil)
+	return ErrNotFound
 }
 
 // getServiceBuildHandler : gets the details of a specific service build
 func getServiceBuildHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
 	var list []ServiceRender
 
 	au := authenticatedUser(c)
@@ -125,18 +132,19 @@ func getServiceBuildHandler(c echo.Context) (err error) {
 		query["group_id"] = au.GroupID
 	}
 
-	if list, err = getServicesOutput(query); err != nil {
-		return c.JSONBlob(500, []byte(err.Error()))
+	if list, err = getServicesOutput(ctx, query); err != nil {
+		return httpError(500, err.Error())
 	}
 
 	if len(list) > 0 {
 		return c.JSON(http.StatusOK, list[0])
 	}
-	return c.JSON(http.StatusNotFound, nil)
+	return ErrNotFound
 }
 
 // TODO : WTF is this doing??
 func searchServicesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	au := authenticatedUser(c)
 
 	query := getSearchFilter(c)
@@ -144,7 +152,7 @@ func searchServicesHandler(c echo.Context) error {
 		query["group_id"] = au.GroupID
 	}
 
-	list, err := getServicesOutput(query)
+	list, err := getServicesOutput(ctx, query)
 	if err != nil {
 		return ErrInternal
 	}
@@ -152,9 +160,42 @@ func searchServicesHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, list)
 }
 
+// getAdminServicesHandler : responds to GET /admin/services/ with a page of
+// the services of all groups, optionally filtered by ?group_id=,
+// ?datacenter_id= and ?name=
+func getAdminServicesHandler(c echo.Context) (err error) {
+	ctx := c.Request().Context()
+	var s Service
+	var services []Service
+
+	au := authenticatedUser(c)
+	if au.Admin != true {
+		return ErrUnauthorized
+	}
+
+	offset, err := decodeCursor(c.QueryParam("cursor"))
+	if err != nil || offset < 0 {
+		return ErrBadReqBody
+	}
+
+	query := make(map[string]interface{})
+	for field, value := range getSearchFilter(c) {
+		if field == "group_id" || field == "datacenter_id" || field == "name" {
+			query[field] = value
+		}
+	}
+
+	if err = s.Find(ctx, query, &services); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, PaginateServices(services, offset, pageLimit(c)))
+}
+
 // resetServiceHandler : Respons to POST /services/:service/reset/ and updates the
 // service status to errored from in_progress
 func resetServiceHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var s Service
 	var services []Service
 
@@ -164,13 +205,13 @@ func resetServiceHandler(c echo.Context) error {
 	filter := make(map[string]interface{})
 	filter["group_id"] = au.GroupID
 	filter["name"] = name
-	if err := s.Find(filter, &services); err != nil {
-		log.Println(err.Error())
-		return c.JSONBlob(500, []byte("Internal Error"))
+	if err := s.Find(ctx, filter, &services); err != nil {
+		logError(c, err)
+		return echo.NewHTTPError(500, "Internal Error")
 	}
 
 	if len(services) == 0 {
-		return c.JSONBlob(404, []byte("Service not found with this name"))
+		return echo.NewHTTPError(404, "Service not found with this name")
 	}
 
 	s = services[0]
@@ -179,9 +220,9 @@ func resetServiceHandler(c echo.Context) error {
 		return c.JSONBlob(200, []byte("Reset only applies to 'in progress' serices, however service '"+name+"' is on status '"+s.Status))
 	}
 
-	if err := s.Reset(); err != nil {
-		log.Println(err.Error())
-		return c.JSONBlob(500, []byte("Internal error"))
+	if err := s.Reset(ctx); err != nil {
+		logError(c, err)
+		return echo.NewHTTPError(500, "Internal error")
 	}
 
 	return c.String(200, "success")
@@ -194,11 +235,11 @@ func createUUIDHandler(c echo.Context) error {
 	req := c.Request()
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return c.JSONBlob(500, []byte("Invalid input"))
+		return echo.NewHTTPError(500, "Invalid input")
 	}
 
 	if err := json.Unmarshal(body, &s); err != nil {
-		log.Println(err)
+		logError(c, err)
 		return err
 	}
 	id := generateStreamID(s.ID)
@@ -208,6 +249,7 @@ func createUUIDHandler(c echo.Context) error {
 
 // createServiceHandler : Will receive a service application
 func createServiceHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var s ServiceInput
 	var err error
 	var body []byte
@@ -222,29 +264,37 @@ func createServiceHandler(c echo.Context) error {
 	if au.GroupID == 0 {
 		body := "Current user does not belong to any group."
 		body += "\nPlease assign the user to a group before performing this action"
-		return c.JSONBlob(401, []byte(body))
+		return echo.NewHTTPError(401, body)
 	}
 
 	// Parse the input service as usual
 	if s, definition, body, err = mapInputService(c); err != nil {
-		return c.JSONBlob(400, []byte(err.Error()))
+		return httpError(400, err.Error())
 	}
 	payload.Service = (*json.RawMessage)(&body)
 
 	// Get datacenter
-	if datacenter, err = getDatacenter(s.Datacenter, au.GroupID); err != nil {
-		return c.JSONBlob(404, []byte(err.Error()))
+	if datacenter, err = getDatacenter(ctx, s.Datacenter, au.GroupID); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
+		return httpError(404, err.Error())
+	}
+	var d Datacenter
+	if err = json.Unmarshal(datacenter, &d); err == nil && !d.IsEnabled() {
+		return echo.NewHTTPError(400, "Specified datacenter is disabled")
 	}
 	payload.Datacenter = (*json.RawMessage)(&datacenter)
 
 	// Get group
-	if group, err = getGroup(au.GroupID); err != nil {
-		return c.JSONBlob(http.StatusNotFound, []byte(err.Error()))
+	if group, err = getGroup(ctx, au.GroupID); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
+		return httpError(http.StatusNotFound, err.Error())
 	}
 	payload.Group = (*json.RawMessage)(&group)
 	var currentUser User
-	if err := currentUser.FindByUserName(au.Username, &currentUser); err != nil {
-		log.Println(err)
+	if err := currentUser.FindByUserName(ctx, au.Username, &currentUser); err != nil {
+		logError(c, err)
 		return err
 	}
 
@@ -252,14 +302,16 @@ func createServiceHandler(c echo.Context) error {
 	payload.ID = generateServiceID(s.Name + "-" + s.Datacenter)
 
 	// Get previous service if exists
-	if previous, err = getService(s.Name, au.GroupID); err != nil {
-		return c.JSONBlob(http.StatusNotFound, []byte(err.Error()))
+	if previous, err = getService(ctx, s.Name, au.GroupID); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
+		return httpError(http.StatusNotFound, err.Error())
 	}
 
 	if previous != nil {
 		payload.PrevID = previous.ID
 		if previous.Status == "in_progress" {
-			return c.JSONBlob(http.StatusNotFound, []byte(`"Your service process is 'in progress' if your're sure you want to fix it please reset it first"`))
+			return echo.NewHTTPError(http.StatusNotFound, "Your service process is 'in progress' if your're sure you want to fix it please reset it first")
 		}
 	}
 
@@ -270,7 +322,9 @@ func createServiceHandler(c echo.Context) error {
 	if isAnImport == true {
 		mapSubject = "definition.map.import"
 	}
-	if service, err = mapDefinition(payload, mapSubject); err != nil {
+	if service, err = mapDefinition(ctx, payload, mapSubject); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
 		return echo.NewHTTPError(400, err.Error())
 	}
 
@@ -279,7 +333,7 @@ func createServiceHandler(c echo.Context) error {
 		Type string `json:"type"`
 	}
 	if err := json.Unmarshal(datacenter, &datacenterStruct); err != nil {
-		log.Println(err)
+		logError(c, err)
 		return err
 	}
 
@@ -296,7 +350,9 @@ func createServiceHandler(c echo.Context) error {
 		Maped:        string(service),
 	}
 
-	if err := ss.Save(); err != nil {
+	if err := ss.Save(ctx); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
 		return echo.NewHTTPError(500, err.Error())
 	}
 
@@ -305,47 +361,104 @@ func createServiceHandler(c echo.Context) error {
 	if isAnImport == true {
 		subject = "service.import"
 	}
-	if err := n.Publish(subject, service); err != nil {
-		log.Println(err)
+	if err := publish(subject, service); err == errNatsDisconnected {
+		return ErrServiceUnavailable
+	} else if err != nil {
+		logError(c, err)
 		return err
 	}
 
 	return c.JSONBlob(http.StatusOK, []byte(`{"id":"`+payload.ID+`"}`))
 }
 
+// bulkMoveServicesHandler : responds to POST /services/bulk-move/ by pointing
+// all given services to the target datacenter
+func bulkMoveServicesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	var d Datacenter
+	var payload struct {
+		ServiceIDs         []string `json:"service_ids"`
+		TargetDatacenterID int      `json:"target_datacenter_id"`
+	}
+
+	au := authenticatedUser(c)
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return ErrBadReqBody
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ErrBadReqBody
+	}
+
+	if len(payload.ServiceIDs) == 0 || payload.TargetDatacenterID == 0 {
+		return ErrBadReqBody
+	}
+
+	if err := d.FindByID(ctx, payload.TargetDatacenterID); err != nil {
+		return err
+	}
+
+	if au.Admin != true && !au.InGroup(d.GroupID) {
+		return ErrNotFound
+	}
+
+	if !d.IsEnabled() {
+		return echo.NewHTTPError(400, "Specified datacenter is disabled")
+	}
+
+	results := make([]ServiceMoveResult, 0, len(payload.ServiceIDs))
+	errs := make([]string, 0, len(payload.ServiceIDs))
+	for _, id := range payload.ServiceIDs {
+		r := moveService(ctx, au, id, d)
+		results = append(results, r)
+		errs = append(errs, r.Error)
+	}
+
+	return bulkResponse(c, results, errs)
+}
+
 func updateServiceHandler(c echo.Context) error {
 	return echo.NewHTTPError(405, "Not implemented")
 }
 
 // Deletes a service by name
 func deleteServiceHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var raw []byte
 	var err error
 
 	au := authenticatedUser(c)
 
-	if raw, err = getServiceRaw(c.Param("name"), au.GroupID); err != nil {
+	if raw, err = getServiceRaw(ctx, c.Param("name"), au.GroupID); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
 		return echo.NewHTTPError(404, err.Error())
 	}
 
 	s := Service{}
 	if err := json.Unmarshal(raw, &s); err != nil {
-		log.Println(err)
+		logError(c, err)
 		return err
 	}
 
 	if s.Status == "in_progress" {
-		return c.JSONBlob(400, []byte(`"Service is already applying some changes, please wait until they are done"`))
+		return echo.NewHTTPError(400, "Service is already applying some changes, please wait until they are done")
 	}
 
 	query := []byte(`{"previous_id":"` + s.ID + `","datacenter":{"type":"` + s.Type + `"}}`)
-	msg, err := n.Request("definition.map.deletion", query, 1*time.Second)
-	if err != nil {
-		return c.JSONBlob(500, []byte(`"Couldn't map the service"`))
+	msg, err := request(ctx, "definition.map.deletion", query, 1*time.Second)
+	if err == errNatsDisconnected {
+		return ErrServiceUnavailable
+	} else if err != nil {
+		return echo.NewHTTPError(500, "Couldn't map the service")
 	}
-	if err := n.Publish("service.delete", msg.Data); err != nil {
-		log.Println(err)
-		return c.JSONBlob(500, []byte(`"Couldn't call service.delete"`))
+	if err := publish("service.delete", msg.Data); err == errNatsDisconnected {
+		return ErrServiceUnavailable
+	} else if err != nil {
+		logError(c, err)
+		return echo.NewHTTPError(500, "Couldn't call service.delete")
 	}
 
 	parts := strings.Split(s.ID, "-")
@@ -356,23 +469,28 @@ func deleteServiceHandler(c echo.Context) error {
 
 // Deletes a service by name forcing it
 func forceServiceDeletionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var raw []byte
 	var err error
 
 	au := authenticatedUser(c)
 
-	if raw, err = getServiceRaw(c.Param("name"), au.GroupID); err != nil {
+	if raw, err = getServiceRaw(ctx, c.Param("name"), au.GroupID); err == ErrServiceUnavailable {
+		return err
+	} else if err != nil {
 		return echo.NewHTTPError(404, err.Error())
 	}
 
 	s := Service{}
 	if err := json.Unmarshal(raw, &s); err != nil {
-		log.Println(err)
+		logError(c, err)
 		return echo.NewHTTPError(500, err.Error())
 	}
 
-	if err := n.Publish("service.del", []byte(`{"name":"`+c.Param("name")+`"}`)); err != nil {
-		log.Println(err)
+	if err := publish("service.del", []byte(`{"name":"`+c.Param("name")+`"}`)); err == errNatsDisconnected {
+		return ErrServiceUnavailable
+	} else if err != nil {
+		logError(c, err)
 		return echo.NewHTTPError(500, err.Error())
 	}
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -30,6 +31,14 @@ type ServicePayload struct {
 	Service    *json.RawMessage `json:"service"`
 }
 
+// ServiceMoveResult : outcome of moving a single service on a bulk move
+type ServiceMoveResult struct {
+	ID           string `json:"id"`
+	DatacenterID int    `json:"datacenter_id"`
+	Moved        bool   `json:"moved"`
+	Error        string `json:"error,omitempty"`
+}
+
 // Given an echo context, it will extract the json or yml
 // request body and will processes it in order to extract
 // a valid defintion
@@ -78,14 +87,15 @@ func generateStreamID(salt string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-func getDatacenter(name string, group int) (datacenter []byte, err error) {
+func getDatacenter(ctx context.Context, name string, group int) (datacenter []byte, err error) {
 	var d Datacenter
 	var datacenters []Datacenter
 
-	if err := d.FindByNameAndGroupID(name, group, &datacenters); err != nil {
+	if err := d.FindByNameAndGroupID(ctx, name, group, &datacenters); err != nil {
 		return datacenter, err
 	}
 
+	datacenters = NotDeleted(datacenters)
 	if len(datacenters) == 0 {
 		return datacenter, errors.New(`"Specified datacenter does not exist"`)
 	}
@@ -98,10 +108,12 @@ func getDatacenter(name string, group int) (datacenter []byte, err error) {
 	return datacenter, nil
 }
 
-func getGroup(id int) (group []byte, err error) {
+func getGroup(ctx context.Context, id int) (group []byte, err error) {
 	var g Group
 
-	if err = g.FindByID(id); err != nil {
+	if err = g.FindByID(ctx, id); err == ErrServiceUnavailable {
+		return group, err
+	} else if err != nil {
 		return group, errors.New(`"Specified group does not exist"`)
 	}
 
@@ -113,11 +125,13 @@ func getGroup(id int) (group []byte, err error) {
 	return group, nil
 }
 
-func getService(name string, group int) (service *Service, err error) {
+func getService(ctx context.Context, name string, group int) (service *Service, err error) {
 	var s Service
 	var services []Service
 
-	if err = s.FindByNameAndGroupID(name, group, &services); err != nil {
+	if err = s.FindByNameAndGroupID(ctx, name, group, &services); err == ErrServiceUnavailable {
+		return service, err
+	} else if err != nil {
 		return service, ErrGatewayTimeout
 	}
 
@@ -128,14 +142,16 @@ func getService(name string, group int) (service *Service, err error) {
 	return &services[0], nil
 }
 
-func mapDefinition(payload ServicePayload, subject string) (body []byte, err error) {
+func mapDefinition(ctx context.Context, payload ServicePayload, subject string) (body []byte, err error) {
 	var msg *nats.Msg
 
 	if body, err = json.Marshal(payload); err != nil {
 		return body, errors.New("Provided yaml is not valid")
 	}
 
-	if msg, err = n.Request(subject, body, 1*time.Second); err != nil {
+	if msg, err = request(ctx, subject, body, 1*time.Second); err == errNatsDisconnected {
+		return body, ErrServiceUnavailable
+	} else if err != nil {
 		return body, errors.New("Provided yaml is not valid")
 	}
 
@@ -154,11 +170,13 @@ func mapDefinition(payload ServicePayload, subject string) (body []byte, err err
 	return msg.Data, nil
 }
 
-func getServiceRaw(name string, group int) (service []byte, err error) {
+func getServiceRaw(ctx context.Context, name string, group int) (service []byte, err error) {
 	var s Service
 	var services []Service
 
-	if err = s.FindByNameAndGroupID(name, group, &services); err != nil {
+	if err = s.FindByNameAndGroupID(ctx, name, group, &services); err == ErrServiceUnavailable {
+		return nil, err
+	} else if err != nil {
 		return nil, errors.New(`"Internal error"`)
 	}
 
@@ -173,14 +191,54 @@ func getServiceRaw(name string, group int) (service []byte, err error) {
 	return body, nil
 }
 
-func getServicesOutput(filter map[string]interface{}) (list []ServiceRender, err error) {
+func getServicesOutput(ctx context.Context, filter map[string]interface{}) (list []ServiceRender, err error) {
 	var s Service
 	var services []Service
 	var o ServiceRender
 
-	if err := s.Find(filter, &services); err != nil {
+	if err := s.Find(ctx, filter, &services); err != nil {
 		return list, err
 	}
 
-	return o.RenderCollection(services)
+	return o.RenderCollection(ctx, services)
+}
+
+// Moves the service with the given id to the specified datacenter, as
+// long as the user has access to it and both share the same type
+func moveService(ctx context.Context, au User, id string, d Datacenter) (r ServiceMoveResult) {
+	var s Service
+	var services []Service
+
+	r.ID = id
+
+	query := make(map[string]interface{})
+	query["id"] = id
+
+	if err := s.Find(ctx, query, &services); err != nil {
+		r.Error = "Internal error"
+		return r
+	}
+
+	if len(services) == 0 || (au.Admin != true && !au.InGroup(services[0].GroupID)) {
+		r.Error = "Service not found"
+		return r
+	}
+
+	s = services[0]
+	r.DatacenterID = s.DatacenterID
+
+	if !d.Supports(s.Type) {
+		r.Error = "Service type '" + s.Type + "' is not supported by datacenter type '" + d.Type + "'"
+		return r
+	}
+
+	if err := s.MoveTo(ctx, d.ID); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	r.DatacenterID = d.ID
+	r.Moved = true
+
+	return r
 }
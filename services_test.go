@@ -5,10 +5,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"os"
 	"strings"
 	"testing"
 
+	"github.com/labstack/echo"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -16,6 +19,163 @@ func TestServices(t *testing.T) {
 	testsSetup()
 	setup()
 
+	Convey("Scenario: moving services to another datacenter", t, func() {
+		Convey("Given the target datacenter has the same type as the services", func() {
+			data := []byte(`{"service_ids":["1","3"],"target_datacenter_id":2}`)
+			getDatacenterSubscriber(1)
+			foundSubscriber("service.set", `"success"`, 2)
+			findServiceSubscriber(2)
+
+			Convey("When I call POST /services/bulk-move/", func() {
+				resp, err := doRequest("POST", "/services/bulk-move/", nil, data, bulkMoveServicesHandler, nil)
+
+				Convey("Then all services should point to the new datacenter", func() {
+					var r []ServiceMoveResult
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &r)
+					So(err, ShouldBeNil)
+					So(len(r), ShouldEqual, 2)
+					So(r[0].ID, ShouldEqual, "1")
+					So(r[0].Moved, ShouldBeTrue)
+					So(r[0].DatacenterID, ShouldEqual, 2)
+					So(r[1].ID, ShouldEqual, "3")
+					So(r[1].Moved, ShouldBeTrue)
+					So(r[1].DatacenterID, ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("Given the target datacenter has a different type", func() {
+			data := []byte(`{"service_ids":["2"],"target_datacenter_id":2}`)
+			getDatacenterSubscriber(1)
+			findServiceSubscriber(1)
+
+			Convey("When I call POST /services/bulk-move/", func() {
+				_, err := doRequest("POST", "/services/bulk-move/", nil, data, bulkMoveServicesHandler, nil)
+
+				Convey("Then I should get a 400 error as no service was moved", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 400)
+				})
+			})
+		})
+
+		Convey("Given the target datacenter is disabled", func() {
+			data := []byte(`{"service_ids":["1"],"target_datacenter_id":2}`)
+			foundSubscriber("datacenter.get", `{"id":2,"group_id":1,"type":"aws","enabled":false}`, 1)
+
+			Convey("When I call POST /services/bulk-move/", func() {
+				_, err := doRequest("POST", "/services/bulk-move/", nil, data, bulkMoveServicesHandler, nil)
+
+				Convey("Then I should get a 400 error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 400)
+				})
+			})
+		})
+
+		Convey("Given the target datacenter is deleted", func() {
+			data := []byte(`{"service_ids":["1"],"target_datacenter_id":2}`)
+			foundSubscriber("datacenter.get", `{"id":2,"group_id":1,"type":"aws","deleted":true}`, 1)
+
+			Convey("When I call POST /services/bulk-move/", func() {
+				_, err := doRequest("POST", "/services/bulk-move/", nil, data, bulkMoveServicesHandler, nil)
+
+				Convey("Then I should get a 404 error", func() {
+					So(err, ShouldEqual, ErrNotFound)
+				})
+			})
+		})
+
+		Convey("Given the services belong to another group of the user", func() {
+			data := []byte(`{"service_ids":["1","2"],"target_datacenter_id":2}`)
+			ft := generateTestTokenWithGroups(3, "test", 1)
+			foundSubscriber("datacenter.get", `{"id":2,"group_id":3,"type":"aws"}`, 1)
+			foundSubscriber("service.set", `"success"`, 1)
+			findServiceSubscriber(2)
+
+			Convey("When I call POST /services/bulk-move/", func() {
+				resp, err := doRequest("POST", "/services/bulk-move/", nil, data, bulkMoveServicesHandler, ft)
+
+				Convey("Then only the services of its groups should be moved", func() {
+					var r []ServiceMoveResult
+					So(err, ShouldBeNil)
+					So(json.Unmarshal(resp, &r), ShouldBeNil)
+					So(len(r), ShouldEqual, 2)
+					So(r[0].Moved, ShouldBeTrue)
+					So(r[1].Moved, ShouldBeFalse)
+					So(r[1].Error, ShouldEqual, "Service not found")
+				})
+			})
+		})
+	})
+
+	Convey("Scenario: getting a list of services of a type", t, func() {
+		Convey("Given services of different types exist on the store", func() {
+			foundSubscriber("service.find", `[{"id":"1","name":"db","type":"aws"},{"id":"2","name":"web","type":"vcloud"},{"id":"3","name":"cache","type":"aws"}]`, 1)
+			foundSubscriber("user.find", `[]`, 1)
+			Convey("When I call GET /services/?type=aws", func() {
+				resp, err := doRequest("GET", "/services/?type=aws", nil, nil, getServicesHandler, nil)
+
+				Convey("Then only services of that type should be returned", func() {
+					var s []Service
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &s)
+					So(err, ShouldBeNil)
+					So(len(s), ShouldEqual, 2)
+					So(s[0].Name, ShouldEqual, "db")
+					So(s[1].Name, ShouldEqual, "cache")
+				})
+			})
+		})
+	})
+
+	Convey("Scenario: getting the services of all groups", t, func() {
+		Convey("Given services of several groups exist on the store", func() {
+			Convey("When I call GET /admin/services/ as an admin user", func() {
+				findServiceSubscriber(1)
+				resp, err := doRequest("GET", "/admin/services/?limit=2", nil, nil, getAdminServicesHandler, nil)
+
+				Convey("Then I should get the first page of all services", func() {
+					var p ServicePage
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &p)
+					So(err, ShouldBeNil)
+					So(len(p.Services), ShouldEqual, 2)
+					So(p.Services[0].ID, ShouldEqual, "1")
+					So(p.Services[1].ID, ShouldEqual, "2")
+					So(p.NextCursor, ShouldNotEqual, "")
+				})
+			})
+
+			Convey("When I call GET /admin/services/?name=test2 as an admin user", func() {
+				findServiceSubscriber(1)
+				resp, err := doRequest("GET", "/admin/services/?name=test2", nil, nil, getAdminServicesHandler, nil)
+
+				Convey("Then only the matching services should be returned", func() {
+					var p ServicePage
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &p)
+					So(err, ShouldBeNil)
+					So(len(p.Services), ShouldEqual, 1)
+					So(p.Services[0].Name, ShouldEqual, "test2")
+					So(p.Services[0].GroupID, ShouldEqual, 2)
+					So(p.NextCursor, ShouldEqual, "")
+				})
+			})
+
+			Convey("When I call GET /admin/services/ as a non admin user", func() {
+				ft := generateTestToken(1, "test", false)
+				_, err := doRequest("GET", "/admin/services/", nil, nil, getAdminServicesHandler, ft)
+
+				Convey("Then I should get a 403 error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.(*echo.HTTPError).Code, ShouldEqual, 403)
+				})
+			})
+		})
+	})
+
 	Convey("Scenario: reeting a service", t, func() {
 		foundSubscriber("service.set", `"success"`, 1)
 		foundSubscriber("service.get.mapping", `{"name":"test", "networks":{"items":[{"name":"a"}]}}`, 2)
@@ -82,13 +242,14 @@ func TestServices(t *testing.T) {
 	})
 
 	Convey("Scenario: getting a single service", t, func() {
+		resetSubscribers()
 		Convey("Given the service do not exist on the store", func() {
 			foundSubscriber("service.find", `[]`, 3)
 			Convey("And I call /service/:service on the api", func() {
 				params := make(map[string]string)
 				params["service"] = "1"
 				_, err := doRequest("GET", "/services/:service", params, nil, getServiceHandler, nil)
-				So(err, ShouldBeNil)
+				So(err, ShouldEqual, ErrNotFound)
 			})
 		})
 		Convey("Given the service exists on the store", func() {
@@ -135,7 +296,7 @@ func TestServices(t *testing.T) {
 		Convey("Given the service exists on the store", func() {
 			Convey("And I call /service/:service/builds/ on the api", func() {
 				foundSubscriber("service.get.mapping", `{"name":"test", "networks":{"items":[{"name":"a"}]}}`, 4)
-				findServiceSubscriber()
+				findServiceSubscriber(1)
 				var s []ServiceRender
 				params := make(map[string]string)
 				params["service"] = "test"
@@ -154,7 +315,7 @@ func TestServices(t *testing.T) {
 				})
 
 				Convey("When the service group matches the authenticated users group", func() {
-					findServiceSubscriber()
+					findServiceSubscriber(1)
 					ft := generateTestToken(1, "test", false)
 
 					params := make(map[string]string)
@@ -177,7 +338,7 @@ func TestServices(t *testing.T) {
 	Convey("Scenario: getting a service's build", t, func() {
 		Convey("Given the service exists on the store", func() {
 			Convey("And I call /service/:service/builds/:build on the api", func() {
-				findServiceSubscriber()
+				findServiceSubscriber(1)
 				foundSubscriber("service.get.mapping", `{"name":"test", "networks":{"items":[{"name":"a"}]}}`, 4)
 				var s ServiceRender
 
@@ -198,7 +359,7 @@ func TestServices(t *testing.T) {
 				})
 
 				Convey("When the service group matches the authenticated users group", func() {
-					findServiceSubscriber()
+					findServiceSubscriber(1)
 					foundSubscriber("service.get.mapping", `{"name":"test", "networks":{"items":[{"name":"a"}]}}`, 4)
 					ft := generateTestToken(1, "test", false)
 
@@ -221,7 +382,7 @@ func TestServices(t *testing.T) {
 
 	Convey("Scenario: searching for services", t, func() {
 		Convey("Given the service exists on the store", func() {
-			findServiceSubscriber()
+			findServiceSubscriber(1)
 			foundSubscriber("service.get.mapping", `{"name":"test", "networks":{"items":[{"name":"a"}]}}`, 2)
 			Convey("And I call /service/search/ on the api", func() {
 				var s []ServiceRender
@@ -242,7 +403,7 @@ func TestServices(t *testing.T) {
 		})
 
 		Convey("Given the service doesn't exist on the store", func() {
-			findServiceSubscriber()
+			findServiceSubscriber(1)
 			Convey("And I call /service/search/ on the api", func() {
 				var s []ServiceRender
 				params := make(map[string]string)
@@ -269,10 +430,9 @@ func TestServices(t *testing.T) {
 
 			Convey("And the content type is non json and non yaml", func() {
 				data := []byte("bla")
-				resp, err := doRequest("POST", "/services/", params, data, createServiceHandler, nil)
+				_, err := doRequest("POST", "/services/", params, data, createServiceHandler, nil)
 				Convey("Then I should get a 400 response", func() {
-					So(err, ShouldEqual, nil)
-					So(string(resp), ShouldEqual, `"Invalid input format"`)
+					So(err, ShouldResemble, echo.NewHTTPError(400, "Invalid input format"))
 				})
 			})
 
@@ -280,10 +440,9 @@ func TestServices(t *testing.T) {
 				data := []byte("asd")
 				headers := map[string]string{}
 				headers["Content-Type"] = "application/yaml"
-				resp, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
+				_, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
 				Convey("Then I should get a 400 response", func() {
-					So(err, ShouldEqual, nil)
-					So(string(resp), ShouldEqual, `"Invalid input"`)
+					So(err, ShouldResemble, echo.NewHTTPError(400, "Invalid input"))
 				})
 			})
 
@@ -291,10 +450,9 @@ func TestServices(t *testing.T) {
 				data := []byte(`{"name"}`)
 				headers := map[string]string{}
 				headers["Content-Type"] = "application/json"
-				resp, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
+				_, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
 				Convey("Then I should get a 400 response", func() {
-					So(err, ShouldEqual, nil)
-					So(string(resp), ShouldEqual, `"Invalid input"`)
+					So(err, ShouldResemble, echo.NewHTTPError(400, "Invalid input"))
 				})
 			})
 
@@ -310,16 +468,26 @@ func TestServices(t *testing.T) {
 				})
 			})
 
+			Convey("And the specified datacenter is disabled", func() {
+				foundSubscriber("datacenter.find", `[{"id":1,"enabled":false}]`, 1)
+				data := []byte(`{"name":"test"}`)
+				headers := map[string]string{}
+				headers["Content-Type"] = "application/json"
+				_, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
+				Convey("Then I should get a 400 response", func() {
+					So(err, ShouldResemble, echo.NewHTTPError(400, "Specified datacenter is disabled"))
+				})
+			})
+
 			Convey("And the specified group does not exist", func() {
 				notFoundSubscriber("group.get", 1)
 				foundSubscriber("datacenter.find", `[{"id":1}]`, 1)
 				data := []byte(`{"name":"test"}`)
 				headers := map[string]string{}
 				headers["Content-Type"] = "application/json"
-				resp, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
+				_, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
 				Convey("Then I should get a 404 response", func() {
-					So(err, ShouldEqual, nil)
-					So(string(resp), ShouldEqual, `"Specified group does not exist"`)
+					So(err, ShouldResemble, echo.NewHTTPError(404, "Specified group does not exist"))
 				})
 			})
 
@@ -357,10 +525,9 @@ func TestServices(t *testing.T) {
 
 					Convey("And the existing service is in progress", func() {
 						foundSubscriber("service.find", `[{"id":"foo-bar","status":"in_progress"}]`, 1)
-						resp, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
+						_, err := doRequestHeaders("POST", "/services/", params, data, createServiceHandler, nil, headers)
 						Convey("Then I should get an error as an in_progress service can't be modified", func() {
-							So(err, ShouldEqual, nil)
-							So(string(resp), ShouldEqual, `"Your service process is 'in progress' if your're sure you want to fix it please reset it first"`)
+							So(err, ShouldResemble, echo.NewHTTPError(404, "Your service process is 'in progress' if your're sure you want to fix it please reset it first"))
 						})
 					})
 
@@ -399,10 +566,9 @@ func TestServices(t *testing.T) {
 		Convey("Given a service exists with in progress status", func() {
 			foundSubscriber("service.find", `[{"id":"foo-bar","status":"in_progress"}]`, 1)
 			Convey("When I call DELETE /services/:service", func() {
-				req, err := doRequest("DELETE", "/services/:service", params, nil, deleteServiceHandler, ft)
+				_, err := doRequest("DELETE", "/services/:service", params, nil, deleteServiceHandler, ft)
 				Convey("Then I should get a 400 response", func() {
-					So(err, ShouldBeNil)
-					So(string(req), ShouldEqual, `"Service is already applying some changes, please wait until they are done"`)
+					So(err, ShouldResemble, echo.NewHTTPError(400, "Service is already applying some changes, please wait until they are done"))
 				})
 			})
 		})
@@ -422,4 +588,32 @@ func TestServices(t *testing.T) {
 
 		})
 	})
+
+	Convey("Scenario: building the service payload of an encrypted datacenter", t, func() {
+		resetSubscribers()
+		_ = os.Setenv("ENCRYPTION_KEY", "test-encryption-key")
+
+		Convey("Given the datacenter credentials are encrypted on the store", func() {
+			d := Datacenter{ID: 1, Name: "test", GroupID: 1, Type: "aws", AccessKeyID: "key", SecretAccessKey: "secret"}
+			So(d.Encrypt(), ShouldBeNil)
+			stored, _ := json.Marshal([]Datacenter{d})
+			foundSubscriber("datacenter.find", string(stored), 1)
+
+			Convey("When the datacenter is loaded for a service", func() {
+				payload, err := getDatacenter(context.Background(), "test", 1)
+
+				Convey("Then its credentials should be sent decrypted", func() {
+					var found Datacenter
+					So(err, ShouldBeNil)
+					So(json.Unmarshal(payload, &found), ShouldBeNil)
+					So(found.AccessKeyID, ShouldEqual, "key")
+					So(found.SecretAccessKey, ShouldEqual, "secret")
+				})
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("ENCRYPTION_KEY")
+		})
+	})
 }
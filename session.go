@@ -14,3 +14,31 @@ func getSessionsHandler(c echo.Context) error {
 	au := authenticatedUser(c)
 	return c.JSON(http.StatusOK, au)
 }
+
+// Profile holds the identity and permissions of the authenticated user
+type Profile struct {
+	Username string   `json:"username"`
+	GroupID  int      `json:"group_id"`
+	Admin    bool     `json:"admin"`
+	Roles    []string `json:"roles"`
+}
+
+// getProfileHandler : responds to GET /me with the authenticated user
+// profile, as read from the token claims
+func getProfileHandler(c echo.Context) error {
+	au := authenticatedUser(c)
+
+	p := Profile{
+		Username: au.Username,
+		GroupID:  au.GroupID,
+		Admin:    au.Admin,
+		Roles:    []string{},
+	}
+	for _, role := range []string{RoleViewer, RoleOperator, RoleOwner} {
+		if au.HasRole(role) {
+			p.Roles = append(p.Roles, role)
+		}
+	}
+
+	return c.JSON(http.StatusOK, p)
+}
@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProfile(t *testing.T) {
+	Convey("Scenario: getting my profile", t, func() {
+		Convey("Given my token has an operator role", func() {
+			ft := generateTestTokenWithRoles(2, "jane", RoleOperator)
+
+			Convey("When I call /me", func() {
+				resp, err := doRequest("GET", "/me", nil, nil, getProfileHandler, ft)
+
+				Convey("Then it should match the token claims", func() {
+					var p Profile
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &p)
+					So(err, ShouldBeNil)
+					So(p.Username, ShouldEqual, "jane")
+					So(p.GroupID, ShouldEqual, 2)
+					So(p.Admin, ShouldBeFalse)
+					So(p.Roles, ShouldResemble, []string{RoleViewer, RoleOperator})
+				})
+			})
+		})
+
+		Convey("Given I am an admin", func() {
+			ft := generateTestToken(1, "admin", true)
+
+			Convey("When I call /me", func() {
+				resp, err := doRequest("GET", "/me", nil, nil, getProfileHandler, ft)
+
+				Convey("Then it should have every role", func() {
+					var p Profile
+					So(err, ShouldBeNil)
+					err = json.Unmarshal(resp, &p)
+					So(err, ShouldBeNil)
+					So(p.Admin, ShouldBeTrue)
+					So(p.Roles, ShouldResemble, []string{RoleViewer, RoleOperator, RoleOwner})
+				})
+			})
+		})
+	})
+}
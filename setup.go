@@ -6,14 +6,24 @@ package main
 
 import (
 	"os"
+	"strings"
 	"time"
 
-	ecc "github.com/ernestio/ernest-config-client"
 	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
 )
 
 func setup() {
-	n = ecc.NewConfig(os.Getenv("NATS_URI")).Nats()
+	opts, err := natsOptions()
+	if err != nil {
+		panic("Invalid NATS configuration: " + err.Error())
+	}
+	opts = append(opts, natsReconnectOptions()...)
+
+	n, err = nats.Connect(os.Getenv("NATS_URI"), opts...)
+	if err != nil {
+		panic("Can't connect to NATS: " + err.Error())
+	}
 
 	secret = os.Getenv("JWT_SECRET")
 	if secret == "" {
@@ -26,10 +36,52 @@ func setup() {
 	}
 }
 
+// routePrefix : returns the prefix configured on ROUTE_PREFIX for all
+// routes, as "/api/v1"
+func routePrefix() string {
+	return strings.TrimSuffix(os.Getenv("ROUTE_PREFIX"), "/")
+}
+
+// probesWithoutPrefix : checks if the health and metrics probes are served
+// on the root instead of under the route prefix, as configured on
+// PROBES_WITHOUT_PREFIX
+func probesWithoutPrefix() bool {
+	return os.Getenv("PROBES_WITHOUT_PREFIX") == "true"
+}
+
+// setupServer : registers the public and the JWT protected routes under
+// the configured route prefix, the probes on the root when configured so
+func setupServer(e *echo.Echo) {
+	root := e.Group(routePrefix())
+	root.POST("/auth", authenticate)
+	root.GET("/status", getStatusHandler)
+
+	probes := root
+	if probesWithoutPrefix() {
+		probes = e.Group("")
+	}
+	probes.GET("/healthz", getHealthzHandler)
+	probes.GET("/metrics", getMetricsHandler)
+
+	// Setup JWT auth & protected routes
+	api := root.Group("/api")
+	api.Use(jwtAuth([]byte(secret)))
+	api.Use(requireGroup)
+	api.Use(apiKeyRateLimit)
+	if rate, burst := rateLimitConfig(); rate > 0 {
+		limiter := newRateLimiter(rate, burst)
+		limiter.startCleanup(RateLimitCleanupInterval)
+		api.Use(rateLimit(limiter))
+	}
+	api.Use(noStore)
+	setupRoutes(api)
+}
+
 func setupRoutes(api *echo.Group) {
 	// Setup session routes
 	ss := api.Group("/session")
 	ss.GET("/", getSessionsHandler)
+	api.GET("/me", getProfileHandler)
 
 	// Setup user routes
 	u := api.Group("/users")
@@ -53,11 +105,35 @@ func setupRoutes(api *echo.Group) {
 
 	// Setup datacenter routes
 	d := api.Group("/datacenters")
+	d.Use(requireRole(RoleViewer))
+	operator := requireRole(RoleOperator)
+	member := requireGroupMember
 	d.GET("/", getDatacentersHandler)
+	d.GET("/types/in-use/", getDatacenterTypesInUseHandler, cacheControl("types"))
+	d.GET("/recent/", getRecentDatacentersHandler)
+	d.GET("/expiring/", getExpiringDatacentersHandler)
+	d.GET("/eligible/", getEligibleDatacentersHandler)
+	d.GET("/status-summary/", getDatacentersStatusSummaryHandler)
+	d.GET("/count", getDatacentersCountHandler)
+	d.GET("/export", getDatacentersExportHandler)
 	d.GET("/:datacenter", getDatacenterHandler)
-	d.POST("/", createDatacenterHandler)
-	d.PUT("/:datacenter", updateDatacenterHandler)
-	d.DELETE("/:datacenter", deleteDatacenterHandler)
+	d.GET("/:datacenter/history/", getDatacenterHistoryHandler)
+	d.GET("/:datacenter/impact/", getDatacenterImpactHandler)
+	d.GET("/:datacenter/services", getDatacenterServicesHandler)
+	d.GET("/:datacenter/rename-check", getDatacenterRenameCheckHandler)
+	d.GET("/by-external/:ext", getDatacenterByExternalIDHandler)
+	d.POST("/", createDatacenterHandler, operator, member)
+	d.POST("/bulk", bulkCreateDatacentersHandler, operator, member)
+	d.POST("/encrypt/", encryptDatacentersHandler, member)
+	d.POST("/validate-all/", validateAllDatacentersHandler, member)
+	d.POST("/import/validate/", validateDatacentersImportHandler, member)
+	d.POST("/set-enabled/", bulkEnableDatacentersHandler, operator, member)
+	d.POST("/:datacenter/test", testDatacenterHandler, member)
+	d.POST("/:datacenter/restore", restoreDatacenterHandler, operator, member)
+	d.PUT("/:datacenter", updateDatacenterHandler, operator, member)
+	d.PATCH("/:datacenter", patchDatacenterHandler, operator, member)
+	d.PUT("/:datacenter/credentials", rotateDatacenterCredentialsHandler, operator, member)
+	d.DELETE("/:datacenter", deleteDatacenterHandler, operator, member)
 
 	// Setup logger routes
 	l := api.Group("/loggers")
@@ -75,11 +151,17 @@ func setupRoutes(api *echo.Group) {
 	s.POST("/", createServiceHandler)
 	s.POST("/import/", createServiceHandler)
 	s.POST("/uuid/", createUUIDHandler)
+	s.POST("/bulk-move/", bulkMoveServicesHandler)
 	s.POST("/:service/reset/", resetServiceHandler)
 	s.PUT("/:service", updateServiceHandler)
 	s.DELETE("/:name", deleteServiceHandler)
 	s.DELETE("/:name/force/", forceServiceDeletionHandler)
 
+	// Setup admin routes
+	a := api.Group("/admin")
+	a.GET("/services/", getAdminServicesHandler)
+	a.GET("/config", getAdminConfigHandler)
+
 	// Setup components
 	comp := api.Group("/components")
 	comp.GET("/nats/", getAllComponentsHandler)
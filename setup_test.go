@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serve(e *echo.Echo, method, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRoutePrefix(t *testing.T) {
+	Convey("Scenario: serving routes under a prefix", t, func() {
+		Convey("Given a route prefix is configured", func() {
+			_ = os.Setenv("ROUTE_PREFIX", "/api/v1/")
+			e := echo.New()
+			setupServer(e)
+
+			Convey("Then routes should respond under the prefix", func() {
+				So(serve(e, "GET", "/api/v1/status").Code, ShouldEqual, 200)
+				So(serve(e, "GET", "/api/v1/api/datacenters/").Code, ShouldEqual, 400)
+			})
+
+			Convey("Then routes should not respond without the prefix", func() {
+				So(serve(e, "GET", "/status").Code, ShouldEqual, 404)
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("ROUTE_PREFIX")
+			})
+		})
+
+		Convey("Given the probes are served without the route prefix", func() {
+			_ = os.Setenv("ROUTE_PREFIX", "/api/v1/")
+			_ = os.Setenv("PROBES_WITHOUT_PREFIX", "true")
+			e := echo.New()
+			setupServer(e)
+
+			Convey("Then the probes should respond on the root", func() {
+				So(serve(e, "GET", "/healthz").Code, ShouldNotEqual, 404)
+				So(serve(e, "GET", "/metrics").Code, ShouldEqual, 200)
+				So(serve(e, "GET", "/api/v1/healthz").Code, ShouldEqual, 404)
+			})
+
+			Convey("Then the other routes should keep the prefix", func() {
+				So(serve(e, "GET", "/api/v1/status").Code, ShouldEqual, 200)
+				So(serve(e, "GET", "/status").Code, ShouldEqual, 404)
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("ROUTE_PREFIX")
+				_ = os.Unsetenv("PROBES_WITHOUT_PREFIX")
+			})
+		})
+
+		Convey("Given no route prefix is configured", func() {
+			e := echo.New()
+			setupServer(e)
+
+			Convey("Then routes should respond on the root", func() {
+				So(serve(e, "GET", "/status").Code, ShouldEqual, 200)
+			})
+		})
+	})
+}
@@ -0,0 +1,65 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+)
+
+// DefaultShutdownGrace : time given to in-flight requests to complete once
+// the gateway is asked to stop
+const DefaultShutdownGrace = 30 * time.Second
+
+// shutdownGrace : returns the time given to in-flight requests to complete,
+// as configured on SHUTDOWN_GRACE_PERIOD with a duration like "10s"
+func shutdownGrace() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("SHUTDOWN_GRACE_PERIOD")); err == nil && v > 0 {
+		return v
+	}
+	return DefaultShutdownGrace
+}
+
+// shutdown : stops accepting connections and waits up to the grace period
+// for in-flight requests, draining the NATS connection afterwards so their
+// replies aren't lost
+func shutdown(e *echo.Echo, nc *nats.Conn, grace time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	err := e.Shutdown(ctx)
+
+	if nc != nil {
+		if derr := nc.Drain(); derr != nil {
+			log.Println("Can't drain NATS connection: " + derr.Error())
+			nc.Close()
+		}
+	}
+
+	return err
+}
+
+// waitForShutdown : blocks until SIGTERM or SIGINT is received and then
+// shuts the server down gracefully
+func waitForShutdown(e *echo.Echo) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	<-quit
+
+	log.Println("stopping gateway")
+	if err := shutdown(e, n, shutdownGrace()); err != nil {
+		log.Println("Can't stop gateway gracefully: " + err.Error())
+	}
+	if err := shutdownTracing(); err != nil {
+		log.Println("Can't export pending spans: " + err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	testsSetup()
+
+	Convey("Scenario: shutting the gateway down", t, func() {
+		Convey("Given a request is being served", func() {
+			started := make(chan bool)
+			e := echo.New()
+			e.GET("/slow", func(c echo.Context) error {
+				close(started)
+				time.Sleep(200 * time.Millisecond)
+				return c.String(http.StatusOK, "done")
+			})
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			e.Listener = l
+			url := "http://" + l.Addr().String() + "/slow"
+			go func() {
+				_ = e.Start("")
+			}()
+
+			status := make(chan int, 1)
+			go func() {
+				resp, err := http.Get(url)
+				if err != nil {
+					status <- 0
+					return
+				}
+				_ = resp.Body.Close()
+				status <- resp.StatusCode
+			}()
+			<-started
+
+			nc, err := nats.Connect(os.Getenv("NATS_URI"))
+			So(err, ShouldBeNil)
+
+			Convey("When the gateway is shut down", func() {
+				done := make(chan error, 1)
+				go func() {
+					done <- shutdown(e, nc, time.Second)
+				}()
+
+				Convey("Then the request should complete", func() {
+					So(<-status, ShouldEqual, 200)
+					So(<-done, ShouldBeNil)
+
+					Convey("And new connections should be refused", func() {
+						_, err := http.Get(url)
+						So(err, ShouldNotBeNil)
+					})
+
+					Convey("And the NATS connection should be drained", func() {
+						deadline := time.Now().Add(time.Second)
+						for !nc.IsClosed() && time.Now().Before(deadline) {
+							time.Sleep(10 * time.Millisecond)
+						}
+						So(nc.IsClosed(), ShouldBeTrue)
+					})
+				})
+			})
+		})
+	})
+
+	Convey("Scenario: configuring the shutdown grace period", t, func() {
+		Convey("Given no grace period is configured", func() {
+			So(shutdownGrace(), ShouldEqual, DefaultShutdownGrace)
+		})
+
+		Convey("Given a grace period is configured", func() {
+			_ = os.Setenv("SHUTDOWN_GRACE_PERIOD", "5s")
+			So(shutdownGrace(), ShouldEqual, 5*time.Second)
+
+			Reset(func() {
+				_ = os.Unsetenv("SHUTDOWN_GRACE_PERIOD")
+			})
+		})
+	})
+}
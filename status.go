@@ -6,11 +6,55 @@ package main
 
 import (
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/labstack/echo"
 )
 
+// DefaultHealthzTimeout : time to wait for the backend on readiness checks
+// when HEALTHZ_TIMEOUT is not set
+const DefaultHealthzTimeout = time.Second
+
+// HealthStatus holds the gateway health as reported to probes
+type HealthStatus struct {
+	Status  string `json:"status"`
+	NATS    string `json:"nats"`
+	Backend string `json:"backend,omitempty"`
+}
+
 // getStatusHandler : responds to GET /status/
 func getStatusHandler(c echo.Context) (err error) {
 	return c.JSONBlob(http.StatusOK, []byte(`"success"`))
 }
+
+// getHealthzHandler : responds to GET /healthz with 200 when connected to
+// NATS and 503 otherwise. With ?ready=true the backend is also pinged on
+// HEALTHZ_SUBJECT, waiting up to HEALTHZ_TIMEOUT
+func getHealthzHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	h := HealthStatus{Status: "ok", NATS: "connected"}
+
+	if !natsConnected() {
+		h.Status = "unavailable"
+		h.NATS = "disconnected"
+		return c.JSON(http.StatusServiceUnavailable, h)
+	}
+
+	subject := os.Getenv("HEALTHZ_SUBJECT")
+	if c.QueryParam("ready") == "true" && subject != "" {
+		timeout := DefaultHealthzTimeout
+		if v, err := time.ParseDuration(os.Getenv("HEALTHZ_TIMEOUT")); err == nil && v > 0 {
+			timeout = v
+		}
+
+		h.Backend = "reachable"
+		if _, err := request(ctx, subject, []byte(""), timeout); err != nil {
+			h.Status = "unavailable"
+			h.Backend = "unreachable"
+			return c.JSON(http.StatusServiceUnavailable, h)
+		}
+	}
+
+	return c.JSON(http.StatusOK, h)
+}
@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHealthz(t *testing.T) {
+	testsSetup()
+	setup()
+
+	Convey("Scenario: probing the gateway health", t, func() {
+		Convey("Given the gateway is connected to NATS", func() {
+			Convey("When I call GET /healthz without credentials", func() {
+				e := echo.New()
+				setupServer(e)
+				rec := serve(e, "GET", "/healthz")
+
+				Convey("Then it should be healthy", func() {
+					var h HealthStatus
+					So(rec.Code, ShouldEqual, 200)
+					err := json.Unmarshal(rec.Body.Bytes(), &h)
+					So(err, ShouldBeNil)
+					So(h.Status, ShouldEqual, "ok")
+					So(h.NATS, ShouldEqual, "connected")
+				})
+			})
+		})
+
+		Convey("Given a backend subject is configured for readiness", func() {
+			_ = os.Setenv("HEALTHZ_SUBJECT", "healthz.ping")
+			_ = os.Setenv("HEALTHZ_TIMEOUT", "200ms")
+
+			Convey("When the backend replies", func() {
+				sub, _ := n.Subscribe("healthz.ping", func(msg *nats.Msg) {
+					_ = n.Publish(msg.Reply, []byte("pong"))
+				})
+				rec, err := doRequestRecorder("GET", "/healthz?ready=true", nil, nil, getHealthzHandler, nil, nil)
+				_ = sub.Unsubscribe()
+
+				Convey("Then it should be ready", func() {
+					So(err, ShouldBeNil)
+					So(rec.Code, ShouldEqual, 200)
+				})
+			})
+
+			Convey("When the backend does not reply", func() {
+				rec, err := doRequestRecorder("GET", "/healthz?ready=true", nil, nil, getHealthzHandler, nil, nil)
+
+				Convey("Then it should not be ready", func() {
+					var h HealthStatus
+					So(err, ShouldBeNil)
+					So(rec.Code, ShouldEqual, 503)
+					err = json.Unmarshal(rec.Body.Bytes(), &h)
+					So(err, ShouldBeNil)
+					So(h.Backend, ShouldEqual, "unreachable")
+				})
+			})
+
+			Reset(func() {
+				_ = os.Unsetenv("HEALTHZ_SUBJECT")
+				_ = os.Unsetenv("HEALTHZ_TIMEOUT")
+			})
+		})
+	})
+}
@@ -17,3 +17,29 @@ func generateTestToken(group int, user string, admin bool) *jwt.Token {
 	// Create token
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 }
+
+func generateTestTokenWithRoles(group int, user string, roles ...string) *jwt.Token {
+	token := generateTestToken(group, user, false)
+
+	claims := token.Claims.(jwt.MapClaims)
+	list := []interface{}{}
+	for _, r := range roles {
+		list = append(list, r)
+	}
+	claims["roles"] = list
+
+	return token
+}
+
+func generateTestTokenWithGroups(group int, user string, groups ...int) *jwt.Token {
+	token := generateTestToken(group, user, false)
+
+	claims := token.Claims.(jwt.MapClaims)
+	list := []interface{}{}
+	for _, g := range groups {
+		list = append(list, float64(g))
+	}
+	claims["groups"] = list
+
+	return token
+}
@@ -10,12 +10,14 @@ import (
 var (
 	mockGroups = []Group{
 		Group{
-			ID:   1,
-			Name: "test",
+			ID:            1,
+			Name:          "test",
+			DefaultRegion: "eu-west-1",
 		},
 		Group{
-			ID:   2,
-			Name: "test2",
+			ID:           2,
+			Name:         "test2",
+			Entitlements: []string{"azure"},
 		},
 	}
 )
@@ -17,6 +17,7 @@ var (
 		Service{
 			ID:           "1",
 			Name:         "test",
+			Type:         "aws",
 			GroupID:      1,
 			DatacenterID: 1,
 			Version:      time.Now(),
@@ -24,6 +25,7 @@ var (
 		Service{
 			ID:           "3",
 			Name:         "test",
+			Type:         "aws",
 			GroupID:      1,
 			DatacenterID: 1,
 			Version:      time.Now(),
@@ -31,6 +33,7 @@ var (
 		Service{
 			ID:           "2",
 			Name:         "test2",
+			Type:         "vcloud",
 			GroupID:      2,
 			DatacenterID: 3,
 			Version:      time.Now(),
@@ -68,7 +71,7 @@ func getServiceSubscriber() {
 	})
 }
 
-func findServiceSubscriber() {
+func findServiceSubscriber(max int) {
 	sub, _ := n.Subscribe("service.find", func(msg *nats.Msg) {
 		var s []Service
 		var qs Service
@@ -85,7 +88,8 @@ func findServiceSubscriber() {
 		}
 
 		for _, service := range mockServices {
-			if service.Name == qs.Name ||
+			if qs.ID != "" && service.ID == qs.ID ||
+				service.Name == qs.Name ||
 				service.Name == qs.Name && service.Version == qs.Version && qs.GroupID == 0 ||
 				service.Name == qs.Name && service.GroupID == qs.GroupID {
 				s = append(s, service)
@@ -97,7 +101,7 @@ func findServiceSubscriber() {
 			log.Println(err)
 		}
 	})
-	if err := sub.AutoUnsubscribe(1); err != nil {
+	if err := sub.AutoUnsubscribe(max); err != nil {
 		log.Println(err)
 	}
 }
@@ -23,6 +23,16 @@ func doRequest(method string, path string, params map[string]string, data []byte
 }
 
 func doRequestHeaders(method string, path string, params map[string]string, data []byte, fn handle, ft *jwt.Token, headers map[string]string) ([]byte, error) {
+	rec, err := doRequestRecorder(method, path, params, data, fn, ft, headers)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	resp := rec.Body.Bytes()
+	return resp, nil
+}
+
+func doRequestRecorder(method string, path string, params map[string]string, data []byte, fn handle, ft *jwt.Token, headers map[string]string) (*httptest.ResponseRecorder, error) {
 	e := echo.New()
 	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
 
@@ -45,11 +55,10 @@ func doRequestHeaders(method string, path string, params map[string]string, data
 
 	c.SetPath(path)
 	if err := fn(c); err != nil {
-		return []byte(""), err
+		return rec, err
 	}
 
-	resp := rec.Body.Bytes()
-	return resp, nil
+	return rec, nil
 }
 
 func testsSetup() {
@@ -60,3 +69,10 @@ func testsSetup() {
 		log.Println(err)
 	}
 }
+
+// resetSubscribers : reconnects to nats, dropping the subscribers left by
+// the previous scenarios so they can't answer the requests of the next one
+func resetSubscribers() {
+	n.Close()
+	setup()
+}
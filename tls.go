@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultTLSMinVersion : minimum TLS version accepted when nothing
+// else is configured
+const DefaultTLSMinVersion = tls.VersionTLS12
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsMinVersion : maps a configured version as "1.2" to its tls
+// constant, defaulting to DefaultTLSMinVersion when empty
+func tlsMinVersion(version string) (uint16, error) {
+	if version == "" {
+		return DefaultTLSMinVersion, nil
+	}
+
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.New("Unsupported TLS version " + version)
+	}
+
+	return v, nil
+}
+
+// tlsConfig : builds the tls configuration used when terminating TLS,
+// any handshake below the configured minimum version is rejected
+func tlsConfig(minVersion string) (*tls.Config, error) {
+	version, err := tlsMinVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{MinVersion: version}, nil
+}
+
+// tlsFiles : returns the certificate and key files configured on
+// TLS_CERT_FILE and TLS_KEY_FILE, failing when only one of them is set
+func tlsFiles() (certFile string, keyFile string, err error) {
+	certFile = os.Getenv("TLS_CERT_FILE")
+	keyFile = os.Getenv("TLS_KEY_FILE")
+
+	if (certFile == "") != (keyFile == "") {
+		return "", "", errors.New("Both TLS_CERT_FILE and TLS_KEY_FILE must be set to enable TLS")
+	}
+
+	return certFile, keyFile, nil
+}
+
+// start : starts the server on the given address, terminating TLS
+// when a certificate and key are configured
+func start(e *echo.Echo, address string) error {
+	certFile, keyFile, err := tlsFiles()
+	if err != nil {
+		return err
+	}
+
+	if certFile == "" {
+		return e.Start(address)
+	}
+
+	config, err := tlsConfig(os.Getenv("TLS_MIN_VERSION"))
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	config.Certificates = []tls.Certificate{cert}
+
+	return e.StartServer(&http.Server{
+		Addr:      address,
+		TLSConfig: config,
+	})
+}
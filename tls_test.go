@@ -0,0 +1,79 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTLSConfig(t *testing.T) {
+	Convey("Scenario: building the tls configuration", t, func() {
+		Convey("Given no minimum version is configured", func() {
+			config, err := tlsConfig("")
+			Convey("Then it should default to TLS 1.2", func() {
+				So(err, ShouldBeNil)
+				So(config.MinVersion, ShouldEqual, tls.VersionTLS12)
+			})
+		})
+
+		Convey("Given a minimum version is configured", func() {
+			config, err := tlsConfig("1.3")
+			Convey("Then it should be set on the tls configuration", func() {
+				So(err, ShouldBeNil)
+				So(config.MinVersion, ShouldEqual, tls.VersionTLS13)
+			})
+		})
+
+		Convey("Given an unsupported minimum version is configured", func() {
+			config, err := tlsConfig("2.0")
+			Convey("Then it should return an error", func() {
+				So(err, ShouldNotBeNil)
+				So(config, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Scenario: reading the tls files", t, func() {
+		Convey("Given a certificate and a key are configured", func() {
+			_ = os.Setenv("TLS_CERT_FILE", "cert.pem")
+			_ = os.Setenv("TLS_KEY_FILE", "key.pem")
+			certFile, keyFile, err := tlsFiles()
+
+			Convey("Then both should be returned", func() {
+				So(err, ShouldBeNil)
+				So(certFile, ShouldEqual, "cert.pem")
+				So(keyFile, ShouldEqual, "key.pem")
+			})
+		})
+
+		Convey("Given only a certificate is configured", func() {
+			_ = os.Setenv("TLS_CERT_FILE", "cert.pem")
+			_, _, err := tlsFiles()
+
+			Convey("Then it should return an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given only a key is configured", func() {
+			_ = os.Setenv("TLS_KEY_FILE", "key.pem")
+			err := start(echo.New(), "127.0.0.1:0")
+
+			Convey("Then the server should refuse to start", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			_ = os.Unsetenv("TLS_CERT_FILE")
+			_ = os.Unsetenv("TLS_KEY_FILE")
+		})
+	})
+}
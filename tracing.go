@@ -0,0 +1,152 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceExportTimeout : maximum time to wait for the collector to take each
+// batch of spans, and for the pending ones to be flushed on shutdown
+const TraceExportTimeout = 10 * time.Second
+
+// tracerProvider : starts and exports the spans, nil while tracing is
+// disabled
+var tracerProvider *sdktrace.TracerProvider
+
+// traceContext : reads and writes the W3C trace context headers
+var traceContext = propagation.TraceContext{}
+
+// setupTracing : exports the spans in batches to the OTLP/HTTP collector
+// on TRACE_EXPORTER_URL, as "http://collector:4318/v1/traces", when set
+func setupTracing() error {
+	url := os.Getenv("TRACE_EXPORTER_URL")
+	if url == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(url),
+		otlptracehttp.WithTimeout(TraceExportTimeout),
+	)
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "api-gateway"))),
+	)
+	return nil
+}
+
+// shutdownTracing : exports the pending spans and stops tracing
+func shutdownTracing() error {
+	if tracerProvider == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TraceExportTimeout)
+	defer cancel()
+
+	return tracerProvider.Shutdown(ctx)
+}
+
+// startNATSSpan : starts the span of a NATS request on the given subject
+// as a child of the span on the context, a no-op one when there is none
+func startNATSSpan(ctx context.Context, subject string) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	return parent.TracerProvider().Tracer("api-gateway").Start(ctx, subject,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("messaging.system", "nats"), attribute.String("messaging.destination.name", subject)),
+	)
+}
+
+// natsHeaderCarrier : reads and writes the trace context on the headers of
+// a NATS message, which unlike http ones are case sensitive
+type natsHeaderCarrier nats.Header
+
+// Get : returns the value of the given header
+func (h natsHeaderCarrier) Get(key string) string {
+	return nats.Header(h).Get(key)
+}
+
+// Set : sets the value of the given header
+func (h natsHeaderCarrier) Set(key, value string) {
+	nats.Header(h).Set(key, value)
+}
+
+// Keys : returns the names of the headers
+func (h natsHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// requestGroup : returns the group id of the request token if any
+func requestGroup(c echo.Context) int {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		id, _ := claims["group_id"].(float64)
+		return int(id)
+	}
+	return 0
+}
+
+// tracing : middleware starting a span for each request when tracing is
+// enabled, as a child of the trace on its traceparent header if any. The
+// span is carried on the request context, its trace context returned on
+// the traceparent header, and it is exported once served with the route
+// that handled it, the group of the user and the response status
+func tracing(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if tracerProvider == nil {
+			return next(c)
+		}
+
+		r := c.Request()
+		ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracerProvider.Tracer("api-gateway").Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		c.SetRequest(r.WithContext(ctx))
+		traceContext.Inject(ctx, propagation.HeaderCarrier(c.Response().Header()))
+
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = errorStatus(err)
+		}
+		span.SetAttributes(
+			attribute.String("http.route", c.Path()),
+			attribute.Int("group_id", requestGroup(c)),
+			attribute.Int("http.status_code", status),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,166 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// traceparentFormat : matches a W3C trace context traceparent header
+var traceparentFormat = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// pingHandler : requests the tracing.ping subject with the request context
+func pingHandler(c echo.Context) error {
+	if _, err := request(c.Request().Context(), "tracing.ping", nil, time.Second); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// spanAttributes : returns the attributes of the span by key
+func spanAttributes(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	h := handle(tracing(okHandler))
+
+	Convey("Scenario: exporting request spans", t, func() {
+		exported := make(chan []byte, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Path == "/v1/traces" {
+				exported <- body
+			}
+		}))
+		_ = os.Setenv("TRACE_EXPORTER_URL", collector.URL+"/v1/traces")
+		So(setupTracing(), ShouldBeNil)
+
+		Convey("Given a request continuing a trace", func() {
+			headers := map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, headers)
+
+			Convey("Then a child span should be exported to the collector", func() {
+				So(err, ShouldBeNil)
+				So(rec.Header().Get("traceparent"), ShouldStartWith, "00-4bf92f3577b34da6a3ce929d0e0e4736-")
+				So(rec.Header().Get("traceparent"), ShouldNotContainSubstring, "00f067aa0ba902b7")
+
+				So(tracerProvider.ForceFlush(context.Background()), ShouldBeNil)
+				traceID, _ := hex.DecodeString("4bf92f3577b34da6a3ce929d0e0e4736")
+				So(bytes.Contains(<-exported, traceID), ShouldBeTrue)
+			})
+		})
+
+		Convey("Given a request without trace context", func() {
+			rec, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, nil, nil)
+
+			Convey("Then a new trace should be started", func() {
+				So(err, ShouldBeNil)
+				So(traceparentFormat.MatchString(rec.Header().Get("traceparent")), ShouldBeTrue)
+			})
+		})
+
+		Reset(func() {
+			_ = shutdownTracing()
+			tracerProvider = nil
+			_ = os.Unsetenv("TRACE_EXPORTER_URL")
+			collector.Close()
+		})
+	})
+
+	Convey("Scenario: recording a span per request", t, func() {
+		recorder := tracetest.NewSpanRecorder()
+		tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+		Convey("Given a member of a group calls the api twice", func() {
+			ft := generateTestToken(2, "test2", false)
+			_, err := doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, ft, nil)
+			So(err, ShouldBeNil)
+			_, err = doRequestRecorder("GET", "/api/datacenters/", nil, nil, h, ft, nil)
+			So(err, ShouldBeNil)
+
+			Convey("Then a span should be recorded for each request", func() {
+				spans := recorder.Ended()
+				So(len(spans), ShouldEqual, 2)
+				So(spans[0].SpanContext().TraceID(), ShouldNotEqual, spans[1].SpanContext().TraceID())
+				for _, s := range spans {
+					attrs := spanAttributes(s)
+					So(s.Name(), ShouldEqual, "GET /api/datacenters/")
+					So(attrs["http.route"].AsString(), ShouldEqual, "/api/datacenters/")
+					So(attrs["group_id"].AsInt64(), ShouldEqual, 2)
+					So(attrs["http.status_code"].AsInt64(), ShouldEqual, 200)
+				}
+			})
+		})
+
+		Reset(func() {
+			tracerProvider = nil
+		})
+	})
+
+	Convey("Scenario: tracing NATS requests", t, func() {
+		testsSetup()
+		setup()
+		recorder := tracetest.NewSpanRecorder()
+		tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+		Convey("Given a request sends a NATS request", func() {
+			traceparent := make(chan string, 1)
+			sub, _ := n.Subscribe("tracing.ping", func(msg *nats.Msg) {
+				traceparent <- msg.Header.Get("traceparent")
+				_ = n.Publish(msg.Reply, []byte("pong"))
+			})
+			_, err := doRequestRecorder("GET", "/api/ping/", nil, nil, handle(tracing(pingHandler)), nil, nil)
+			So(err, ShouldBeNil)
+			_ = sub.Unsubscribe()
+
+			Convey("Then it should be traced as a child of the request span", func() {
+				spans := recorder.Ended()
+				So(len(spans), ShouldEqual, 2)
+				child, parent := spans[0], spans[1]
+				So(child.Name(), ShouldEqual, "tracing.ping")
+				So(child.Parent().SpanID(), ShouldEqual, parent.SpanContext().SpanID())
+				So(child.SpanContext().TraceID(), ShouldEqual, parent.SpanContext().TraceID())
+
+				Convey("And its trace context should be sent on the message headers", func() {
+					sc := child.SpanContext()
+					So(<-traceparent, ShouldEqual, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01")
+				})
+			})
+		})
+
+		Convey("Given a NATS request is sent without a request span", func() {
+			_, _ = request(context.Background(), "tracing.ping", nil, 10*time.Millisecond)
+
+			Convey("Then it should not be traced", func() {
+				So(recorder.Ended(), ShouldBeEmpty)
+			})
+		})
+
+		Reset(func() {
+			tracerProvider = nil
+		})
+	})
+}
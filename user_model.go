@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
@@ -34,6 +35,34 @@ type User struct {
 	OldPassword string `json:"oldpassword,omitempty"`
 	Salt        string `json:"salt,omitempty"`
 	Admin       bool   `json:"admin"`
+	// Roles of the user on its group, nil for users issued before roles
+	Roles []string `json:"roles,omitempty"`
+	// Groups the user belongs to when it belongs to more than one
+	Groups []int `json:"groups,omitempty"`
+}
+
+// GroupIDs : returns the ids of all the groups of the user, without
+// duplicates
+func (u *User) GroupIDs() []int {
+	ids := []int{}
+	seen := make(map[int]bool)
+	for _, id := range append([]int{u.GroupID}, u.Groups...) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// InGroup : checks if the user belongs to the given group
+func (u *User) InGroup(id int) bool {
+	for _, g := range u.GroupIDs() {
+		if g == id {
+			return true
+		}
+	}
+	return false
 }
 
 // Validate vaildate all of the user's input
@@ -72,10 +101,10 @@ func (u *User) Map(c echo.Context) *echo.HTTPError {
 
 // FindByUserName : find a user for the given username, and maps it on
 // the fiven User struct
-func (u *User) FindByUserName(name string, user *User) (err error) {
+func (u *User) FindByUserName(ctx context.Context, name string, user *User) (err error) {
 	query := make(map[string]interface{})
 	query["username"] = name
-	if err := NewBaseModel("user").GetBy(query, user); err != nil {
+	if err := NewBaseModel("user").GetBy(ctx, query, user); err != nil {
 		return err
 	}
 	return nil
@@ -83,12 +112,12 @@ func (u *User) FindByUserName(name string, user *User) (err error) {
 
 // FindAll : Searches for all users on the store current user
 // has access to
-func (u *User) FindAll(users *[]User) (err error) {
+func (u *User) FindAll(ctx context.Context, users *[]User) (err error) {
 	query := make(map[string]interface{})
 	if !u.Admin {
 		query["group_id"] = u.GroupID
 	}
-	if err := NewBaseModel("user").FindBy(query, users); err != nil {
+	if err := NewBaseModel("user").FindBy(ctx, query, users); err != nil {
 		return err
 	}
 	return nil
@@ -96,7 +125,7 @@ func (u *User) FindAll(users *[]User) (err error) {
 
 // FindByID : Searches a user by ID on the store current user
 // has access to
-func (u *User) FindByID(id string, user *User) (err error) {
+func (u *User) FindByID(ctx context.Context, id string, user *User) (err error) {
 	query := make(map[string]interface{})
 	if query["id"], err = strconv.Atoi(id); err != nil {
 		return err
@@ -104,32 +133,48 @@ func (u *User) FindByID(id string, user *User) (err error) {
 	if !u.Admin {
 		query["group_id"] = u.GroupID
 	}
-	if err := NewBaseModel("user").GetBy(query, user); err != nil {
+	if err := NewBaseModel("user").GetBy(ctx, query, user); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Save : calls user.set with the marshalled current user
-func (u *User) Save() (err error) {
-	if err := NewBaseModel("user").Save(u); err != nil {
+func (u *User) Save(ctx context.Context) (err error) {
+	if err := NewBaseModel("user").Save(ctx, u); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Delete : will delete a user by its id
-func (u *User) Delete(id string) (err error) {
+func (u *User) Delete(ctx context.Context, id string) (err error) {
 	query := make(map[string]interface{})
 	if query["id"], err = strconv.Atoi(id); err != nil {
 		return err
 	}
-	if err := NewBaseModel("user").Delete(query); err != nil {
+	if err := NewBaseModel("user").Delete(ctx, query); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Role : returns the role of the user on field ACLs, admin for admins, the
+// highest of its roles otherwise or member for users issued before roles
+func (u *User) Role() string {
+	if u.Admin {
+		return "admin"
+	}
+
+	role := "member"
+	for _, r := range u.Roles {
+		if rank, ok := roleRanks[r]; ok && rank > roleRanks[role] {
+			role = r
+		}
+	}
+	return role
+}
+
 // Redact : removes all sensitive fields from the return
 // data before outputting to the user
 func (u *User) Redact() {
@@ -138,8 +183,8 @@ func (u *User) Redact() {
 }
 
 // Improve : adds extra data as group name
-func (u *User) Improve() {
-	g := u.Group()
+func (u *User) Improve(ctx context.Context) {
+	g := u.Group(ctx)
 	u.GroupName = g.Name
 }
 
@@ -170,28 +215,48 @@ func (u *User) ValidPassword(pw string) bool {
 }
 
 // Group : Gets the related user group if any
-func (u *User) Group() (group Group) {
-	if err := group.FindByID(u.GroupID); err != nil {
+func (u *User) Group(ctx context.Context) (group Group) {
+	if err := group.FindByID(ctx, u.GroupID); err != nil {
 		log.Println(err)
 	}
 
 	return group
 }
 
-// Datacenters : Gets the related user datacenters if any
-func (u *User) Datacenters() (ds []Datacenter, err error) {
+// Datacenters : Gets the related user datacenters if any, on all of its
+// groups when it belongs to several
+func (u *User) Datacenters(ctx context.Context) (ds []Datacenter, err error) {
 	var d Datacenter
 
-	err = d.FindByGroupID(u.GroupID, &ds)
+	groups := u.GroupIDs()
+	if len(groups) == 1 {
+		err = d.FindByGroupID(ctx, groups[0], &ds)
+		return ds, err
+	}
+
+	ds = []Datacenter{}
+	seen := make(map[int]bool)
+	for _, id := range groups {
+		var found []Datacenter
+		if err = d.FindByGroupID(ctx, id, &found); err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			if !seen[f.ID] {
+				seen[f.ID] = true
+				ds = append(ds, f)
+			}
+		}
+	}
 
-	return ds, err
+	return ds, nil
 }
 
 // FindAllKeyValue : Finds all users on a id:name hash
-func (u *User) FindAllKeyValue() (list map[int]string) {
+func (u *User) FindAllKeyValue(ctx context.Context) (list map[int]string) {
 	var users []User
 	list = make(map[int]string)
-	if err := u.FindAll(&users); err != nil {
+	if err := u.FindAll(ctx, &users); err != nil {
 		log.Println(err)
 	}
 	for _, v := range users {
@@ -14,16 +14,17 @@ import (
 // users for admin, and all users in your group for other
 // users
 func getUsersHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var users []User
 
 	au := authenticatedUser(c)
-	if err := au.FindAll(&users); err != nil {
+	if err := au.FindAll(ctx, &users); err != nil {
 		return err
 	}
 
 	for i := 0; i < len(users); i++ {
 		users[i].Redact()
-		users[i].Improve()
+		users[i].Improve(ctx)
 	}
 
 	return c.JSON(http.StatusOK, users)
@@ -32,10 +33,11 @@ func getUsersHandler(c echo.Context) error {
 // getUserHandler : responds to GET /users/:id:/ with the specified
 // user details
 func getUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var user User
 
 	au := authenticatedUser(c)
-	if err := au.FindByID(c.Param("user"), &user); err != nil {
+	if err := au.FindByID(ctx, c.Param("user"), &user); err != nil {
 		return err
 	}
 	user.Redact()
@@ -46,6 +48,7 @@ func getUserHandler(c echo.Context) error {
 // createUserHandler : responds to POST /users/ by creating a user
 // on the data store
 func createUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var u User
 	var existing User
 
@@ -57,11 +60,11 @@ func createUserHandler(c echo.Context) error {
 		return ErrBadReqBody
 	}
 
-	if err := existing.FindByUserName(u.Username, &existing); err == nil {
+	if err := existing.FindByUserName(ctx, u.Username, &existing); err == nil {
 		return echo.NewHTTPError(409, "Specified user already exists")
 	}
 
-	if err := u.Save(); err != nil {
+	if err := u.Save(ctx); err != nil {
 		return err
 	}
 
@@ -73,6 +76,7 @@ func createUserHandler(c echo.Context) error {
 // updateUserHandler : responds to PUT /users/:id: by updating an existing
 // user
 func updateUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var u User
 	var existing User
 
@@ -87,7 +91,7 @@ func updateUserHandler(c echo.Context) error {
 	}
 
 	// Check user exists
-	if err := au.FindByID(c.Param("user"), &existing); err != nil {
+	if err := au.FindByID(ctx, c.Param("user"), &existing); err != nil {
 		return err
 	}
 
@@ -105,7 +109,7 @@ func updateUserHandler(c echo.Context) error {
 		return ErrUnauthorized
 	}
 
-	if err := u.Save(); err != nil {
+	if err := u.Save(ctx); err != nil {
 		return err
 	}
 
@@ -117,13 +121,14 @@ func updateUserHandler(c echo.Context) error {
 // deleteUserHandler : responds to DELETE /users/:id: by deleting an
 // existing user
 func deleteUserHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	var au User
 
 	if au = authenticatedUser(c); au.Admin != true {
 		return ErrUnauthorized
 	}
 
-	if err := au.Delete(c.Param("user")); err != nil {
+	if err := au.Delete(ctx, c.Param("user")); err != nil {
 		return err
 	}
 